@@ -10,13 +10,23 @@ import (
 
 func main() {
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGALRM)
 	exit := make(chan bool, 1)
 	go func() {
 		for sig := range sigs {
 			switch sig {
+			case syscall.SIGHUP:
+				fmt.Println("ignored SIGHUP")
 			case syscall.SIGINT:
 				fmt.Println("ignored SIGINT")
+			case syscall.SIGQUIT:
+				fmt.Println("ignored SIGQUIT")
+			case syscall.SIGUSR1:
+				fmt.Println("ignored SIGUSR1")
+			case syscall.SIGUSR2:
+				fmt.Println("ignored SIGUSR2")
+			case syscall.SIGALRM:
+				fmt.Println("ignored SIGALRM")
 			case syscall.SIGTERM:
 				fmt.Println("shutting down after receiving SIGTERM")
 				exit <- true
@@ -1,127 +1,34 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/creack/pty"
-	"github.com/mattn/go-runewidth"
 	"github.com/riywo/loginshell"
 	flag "github.com/spf13/pflag"
+
+	"github.com/zmwangx/ets/pkg/ets"
 )
 
 var version = "unknown"
 
-// Regexp to strip ANSI escape sequences from string. Credit:
-// https://github.com/chalk/ansi-regex/blob/2b56fb0c7a07108e5b54241e8faec160d393aedb/index.js#L4-L7
-// https://github.com/acarl005/stripansi/blob/5a71ef0e047df0427e87a79f27009029921f1f9b/stripansi.go#L7
-var ansiEscapes = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
-
-func printStreamWithTimestamper(r io.Reader, timestamper *Timestamper) {
-	scanner := bufio.NewScanner(r)
-	// Split on \r\n|\r|\n, and return the line as well as the line ending (\r
-	// or \n is preserved, \r\n is collapsed to \n). Adaptation of
-	// bufio.ScanLines.
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-		lfpos := bytes.IndexByte(data, '\n')
-		crpos := bytes.IndexByte(data, '\r')
-		if crpos >= 0 {
-			if lfpos < 0 || lfpos > crpos+1 {
-				// We have a CR-terminated "line".
-				return crpos + 1, data[0 : crpos+1], nil
-			}
-			if lfpos == crpos+1 {
-				// We have a CRLF-terminated line.
-				return lfpos + 1, append(data[0:crpos], '\n'), nil
-			}
-		}
-		if lfpos >= 0 {
-			// We have a LF-terminated line.
-			return lfpos + 1, data[0 : lfpos+1], nil
-		}
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return len(data), data, nil
-		}
-		// Request more data.
-		return 0, nil, nil
-	})
-	for scanner.Scan() {
-		fmt.Print(timestamper.CurrentTimestampString(), " ", scanner.Text())
-	}
-}
+// timeoutExitCode is the exit status when the command times out, as with
+// timeout(1).
+const timeoutExitCode = 124
 
-func runCommandWithTimestamper(args []string, timestamper *Timestamper) error {
-	// Calculate optimal pty size, taking into account horizontal space taken up by timestamps.
-	getPtyWinsize := func() *pty.Winsize {
-		winsize, err := pty.GetsizeFull(os.Stdin)
-		if err != nil {
-			// Most likely stdin isn't a tty, in which case we don't care.
-			return winsize
-		}
-		totalCols := winsize.Cols
-		plainTimestampString := ansiEscapes.ReplaceAllString(timestamper.CurrentTimestampString(), "")
-		// Timestamp width along with one space character.
-		occupiedWidth := uint16(runewidth.StringWidth(plainTimestampString)) + 1
-		var effectiveCols uint16 = 0
-		if occupiedWidth < totalCols {
-			effectiveCols = totalCols - occupiedWidth
-		}
-		winsize.Cols = effectiveCols
-		// Best effort estimate of the effective width in pixels.
-		if totalCols > 0 {
-			winsize.X = winsize.X * effectiveCols / totalCols
-		}
-		return winsize
-	}
-
-	command := exec.Command(args[0], args[1:]...)
-	ptmx, err := pty.StartWithSize(command, getPtyWinsize())
-	if err != nil {
-		return err
-	}
-	defer func() { _ = ptmx.Close() }()
-
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGWINCH, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		for sig := range sigs {
-			switch sig {
-			case syscall.SIGWINCH:
-				if err := pty.Setsize(ptmx, getPtyWinsize()); err != nil {
-					log.Println("error resizing pty:", err)
-				}
-
-			case syscall.SIGINT:
-				_ = syscall.Kill(-command.Process.Pid, syscall.SIGINT)
-
-			case syscall.SIGTERM:
-				_ = syscall.Kill(-command.Process.Pid, syscall.SIGTERM)
-
-			default:
-			}
-		}
-	}()
-	sigs <- syscall.SIGWINCH
-
-	go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
-
-	printStreamWithTimestamper(ptmx, timestamper)
-
-	return command.Wait()
-}
+// stallExitCode is the exit status when the command is terminated for
+// stalling.
+const stallExitCode = 125
 
 func main() {
 	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
@@ -132,6 +39,40 @@ func main() {
 	var utc = flag.BoolP("utc", "u", false, "show absolute timestamps in UTC")
 	var timezoneName = flag.StringP("timezone", "z", "", "show absolute timestamps in this timezone, e.g. America/New_York")
 	var color = flag.BoolP("color", "c", false, "show timestamps in color")
+	var stampAt = flag.String("stamp-at", "end", "timestamp lines when they end or start (when their first byte arrives)")
+	var flushTimeout = flag.Duration("flush-timeout", 0, "print a partial line (e.g. a prompt) after no output for this long, e.g. 200ms")
+	var maxLineLength = flag.Int("max-line-length", 0, "wrap lines longer than this many bytes (0 for no limit)")
+	var wrapMarker = flag.String("wrap-marker", "\\", "marker appended to wrapped lines")
+	var separateStderr = flag.BoolP("separate-stderr", "e", false, "run command with stderr on a separate pty, tagging lines by stream")
+	var noPty = flag.Bool("no-pty", false, "run command with pipes rather than a pty, tagging lines by stream")
+	var exitGrace = flag.Duration("exit-grace", 0, "stop reading output this long after the command exits, even if the pty is still open, e.g. 2s")
+	var timeout = flag.Duration("timeout", 0, "terminate the command if still running after this long, e.g. 10m")
+	var killAfter = flag.Duration("kill-after", ets.DefaultKillGracePeriod, "on --timeout, send SIGKILL if the command is still running this long after SIGTERM")
+	var stallTimeout = flag.Duration("stall-timeout", 0, "warn if the command prints nothing for this long, e.g. 5m")
+	var stallHook = flag.String("stall-hook", "", "shell command to run when the command stalls; $ETS_COMMAND_PID is the command's pid")
+	var stallKill = flag.Bool("stall-kill", false, "terminate the command when it stalls, like on --timeout")
+	var heartbeat = flag.Duration("heartbeat", 0, "print a heartbeat line whenever there's no output for this long, e.g. 1m")
+	var heartbeatEverywhere = flag.Bool("heartbeat-everywhere", false, "also write heartbeats to --log-file and in structured output formats")
+	var summary = flag.Bool("summary", false, "print a summary line at the end: times, line and byte counts, longest gap, exit status")
+	var rusage = flag.Bool("rusage", false, "report the command's resource usage (CPU time, max RSS, page faults, context switches) at exit")
+	var rusageAll = flag.Bool("rusage-all", false, "like --rusage, but report the total over all processes ets has waited for, e.g. also stall hooks and earlier runs with --restart")
+	var sample = flag.Duration("sample", 0, "print the CPU, memory, thread and fd usage of the command's process tree this often, e.g. 10s (Linux only)")
+	var sampleFile = flag.String("sample-file", "", "append --sample lines to this file rather than the output")
+	var multiplex = flag.BoolP("multiplex", "m", false, "run each argument as a [label=]command concurrently, labeling their lines")
+	var exitPolicy = flag.String("exit-policy", "keep", "with --multiplex, when a command exits: keep the others running, stop them, or stop them on first-failure")
+	var restart = flag.String("restart", "", "restart the command when it exits: on-failure or always")
+	var restartDelay = flag.Duration("restart-delay", ets.DefaultRestartDelay, "with --restart, wait this long before the first restart, doubling with every restart")
+	var restartMaxDelay = flag.Duration("restart-max-delay", ets.DefaultMaxRestartDelay, "with --restart, the maximum delay between restarts")
+	var maxRestarts = flag.Int("max-restarts", 0, "with --restart, give up after this many restarts (0 for no limit)")
+	var follow = flag.BoolP("follow", "F", false, "follow the files given as arguments like tail -F, rather than running a command")
+	var output = flag.StringP("output", "o", "plain", "output format: plain, jsonl, or logfmt")
+	var logFile = flag.String("log-file", "", "also append timestamped output to this file")
+	var logStripANSI = flag.Bool("log-strip-ansi", false, "strip ANSI escape sequences from the --log-file copy")
+	var logMaxSize = flag.String("log-max-size", "", "rotate --log-file when it would exceed this size, e.g. 10M")
+	var logRotateInterval = flag.Duration("log-rotate-interval", 0, "rotate --log-file at this interval, e.g. 24h")
+	var logMaxFiles = flag.Int("log-max-files", 0, "number of rotated --log-file files to retain (0 for all)")
+	var forwardSignals = flag.String("forward-signals", defaultForwardedSignals(), "comma-separated signals to forward to the command's process group")
+	var reraiseSignal = flag.Bool("reraise", false, "if the command is terminated by a signal, terminate ets with the same signal")
 	var printHelp = flag.BoolP("help", "h", false, "print help and exit")
 	var printVersion = flag.BoolP("version", "v", false, "print version and exit")
 	flag.CommandLine.SortFlags = false
@@ -148,8 +89,10 @@ Usage:
   %s [-s | -i] [-f format] [-u | -z timezone] command [arg ...]
   %s [options] shell_command
   %s [options]
+  %s [options] -m [label=]shell_command ...
+  %s [options] -F file ...
 
-The three usage strings correspond to three command execution modes:
+The first three usage strings correspond to three command execution modes:
 
 * If given a single command without whitespace(s), or a command and its
   arguments, execute the command with exec in a pty;
@@ -175,14 +118,155 @@ There are three mutually exclusive timestamp modes:
 The default format of the prefixed timestamps depends on the timestamp mode
 active. Users may supply a custom format string with the -f, --format option.
 The format string is basically a strftime(3) format string; see the man page
-or README for details on supported formatting directives.
+or README for details on supported formatting directives. In elapsed and
+incremental modes, %%{days}, %%{hours}, %%{minutes} and %%{seconds} show total
+days, hours, minutes and fractional seconds, without wrapping around after a
+day like %%H or %%T do.
 
 The timezone for absolute timestamps can be controlled via the -u, --utc
 and -z, --timezone options. --timezone accepts IANA time zone names, e.g.,
 America/Los_Angeles. Local time is used by default.
 
+By default, a line is timestamped when it is complete, i.e. when its line
+ending arrives. With --stamp-at=start, it is timestamped with the arrival time
+of its first byte instead, which is more accurate for lines printed slowly.
+
+Lines are only printed once their line ending arrives, so prompts without
+one (e.g. "Password: ") wouldn't show up. With --flush-timeout, a partial
+line is printed with its timestamp after no output for the given duration,
+and the rest of the line continues it without a new timestamp.
+
+Lines may be arbitrarily long. With --max-line-length, longer lines are
+wrapped into several timestamped lines, each but the last ending with
+--wrap-marker.
+
+By default, the command's stdout and stderr share a single pty. With -e,
+--separate-stderr, stderr is attached to a pty of its own, so lines from
+the two streams can be told apart: the %%{stream} format directive expands
+to stdout or stderr, --color shows stderr timestamps in red, and structured
+output formats gain a stream key.
+
+With --no-pty, the command is run with pipes rather than a pty, for commands
+that should not see a terminal, e.g. so that they don't print colors or
+progress bars. stdout and stderr get a pipe each, so lines are tagged by
+stream as with --separate-stderr. The command runs in a process group of its
+own, to which signals are forwarded as usual.
+
+ets reads the command's output until all processes holding its pty have
+exited, which includes background processes started by the command. With
+--exit-grace, ets stops reading the given duration after the command itself
+exits, and reports the number of bytes left unread, if it had to stop early.
+
+With --log-file, timestamped output is also appended to a file, in the same
+output format. --log-strip-ansi strips colors and other ANSI escape sequences
+from the file copy only. The file can be rotated when it would exceed
+--log-max-size, or every --log-rotate-interval; rotated files are named
+FILE.1 (most recent), FILE.2, etc., and at most --log-max-files of them are
+retained.
+
+The -o, --output option selects the output format: plain (the default)
+prefixes each line with its timestamp, while jsonl prints a JSON object per
+line, with the formatted timestamp, the wall time in RFC 3339 format, elapsed
+and incremental durations in nanoseconds, the line, and its terminator (LF,
+CR, or empty for a final unterminated line); logfmt prints records of the
+form ts=... elapsed=... msg="...", where ts is the wall time in RFC 3339
+format and msg is the quoted line.
+
+Signals received by ets are forwarded to the command's process group, so
+that ets is transparent to supervisors sending e.g. SIGHUP to reload. The
+list of signals can be changed with --forward-signals; signals may be given
+by name, with or without the SIG prefix, or by number. An empty list forwards
+none. SIGWINCH is always handled by resizing the command's pty.
+
+Job control works as usual: suspending ets (e.g. with Ctrl-Z) also stops the
+command, and resuming ets (e.g. with fg) continues it, resizing its pty if
+the terminal was resized in the meantime.
+
+When stdin is a terminal, it is switched to raw mode while the command runs,
+so that input is passed through as is, and line editing and echoing are done
+by the command's pty only; interactive programs like vim or less work as
+usual. Signal-generating keys, e.g. Ctrl-C and Ctrl-Z, keep working through
+signal forwarding and job control. The terminal is restored when ets exits
+or is suspended.
+
+If the command is terminated by a signal, ets prints a timestamped notice,
+e.g. "terminated by SIGSEGV", and exits with 128 plus the signal number like
+a shell would. With --reraise, ets attempts to terminate itself with the same
+signal instead.
+
+With --timeout, SIGTERM is sent to the command's process group if the command
+is still running after the given duration, followed by SIGKILL if it is still
+running --kill-after later. Both are announced with timestamped notices, and
+ets exits with status 124, like timeout(1).
+
+With --stall-timeout, ets prints a timestamped warning when the command prints
+nothing for the given duration, once until it prints again. --stall-hook runs
+a shell command at that point, e.g. to dump stack traces, with the command's
+pid in $ETS_COMMAND_PID; its output goes to stderr. With --stall-kill, the
+command is then terminated like on timeout, and ets exits with status 125.
+
+With --heartbeat, ets prints a line of the form "… still running (elapsed
+00:12:00)" whenever there's no output for the given duration, as proof of
+liveness. Heartbeats never interrupt a partially printed line, and are only
+meant for the terminal: they are left out of --log-file and structured output
+formats, unless --heartbeat-everywhere is given.
+
+With --summary, ets prints a summary line at the end, e.g. "started
+2024-01-01 12:00:00, ended 2024-01-01 12:03:30, elapsed 3m30.002s, 120 lines,
+4096 bytes, longest gap 1m2.5s before "Linking...", exited with status 0".
+
+With --rusage, ets reports the command's resource usage when it exits: user
+and system CPU time, maximum resident set size, page faults and context
+switches, including those of descendants the command waited for, as with
+time(1). --rusage-all reports the total over all processes ets has waited for
+instead, which also covers stall hooks and earlier runs with --restart, but
+like --rusage not descendants that were never waited for, e.g. daemons.
+Structured output formats include the figures as event data.
+
+With --sample, ets periodically samples the command and its descendants from
+/proc while it runs, and prints a line of the form "CPU 85.0%%, RSS 45.2 MiB, 3
+processes, 12 threads, 25 fds" among its output, so that slow stretches can be
+told apart from memory pressure. CPU usage is since the previous sample, in
+percent of a single CPU. --sample-file appends these lines to a file instead.
+Only supported on Linux.
+
+With -m, --multiplex, each argument is a command, run concurrently with the
+others, e.g. services run side by side. Commands with whitespace are run by
+the shell as above. Lines are prefixed with the command's label after the
+timestamp, docker-compose style, each label in a color of its own with
+--color; the label is also available as the %%{label} format directive, and
+as a key in structured output formats. The label is given as label=command,
+and defaults to the command's first word. Commands get no input. When a
+command exits, its exit status is printed, and --exit-policy determines what
+happens to the others: with keep (the default), they keep running; with stop,
+they are terminated like on timeout; with first-failure, they are terminated
+if the command failed. ets exits with the status of the first command to fail
+(or with stop, the first to exit), or 0.
+
+With --restart=on-failure, the command is restarted whenever it exits with a
+nonzero status or is terminated by a signal (including by --timeout or
+--stall-kill, which apply to each run); with --restart=always, whenever it
+exits. Restarts are announced with lines of the form "restarting (attempt 3)
+after exit 1". The first restart happens after --restart-delay, and the delay
+doubles with every restart up to --restart-max-delay; it is reset once the
+command has run for at least --restart-max-delay. --max-restarts limits the
+number of restarts. The %%{restart} format directive expands to the number of
+restarts so far. The command isn't restarted if ets receives SIGINT, SIGQUIT
+or SIGTERM, e.g. from Ctrl-C, within --kill-after before the command exits or
+while waiting to restart it, and ets exits with the status of the last run.
+SIGHUP, which commonly asks the command to reload, doesn't prevent restarts.
+
+With -F, --follow, ets follows the given files like tail -F rather than
+running a command, timestamping lines appended to them as they arrive, e.g.
+logs of processes ets doesn't run. Existing content is skipped. Files that are
+replaced (e.g. rotated by renaming) are followed by name, files that are
+truncated are read again from the start, and files that don't exist yet are
+waited for; each is announced with a timestamped notice. With more than one
+file, lines are labeled with their file's name, as with --multiplex. ets
+follows until it receives SIGHUP, SIGINT or SIGTERM, e.g. from Ctrl-C.
+
 Options:
-`, os.Args[0], os.Args[0], os.Args[0])
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -197,21 +281,21 @@ Options:
 		os.Exit(0)
 	}
 
-	mode := AbsoluteTimeMode
+	mode := ets.AbsoluteTimeMode
 	if *elapsedMode && *incrementalMode {
 		log.Fatal("conflicting flags --elapsed and --incremental")
 	}
 	if *elapsedMode {
-		mode = ElapsedTimeMode
+		mode = ets.ElapsedTimeMode
 	}
 	if *incrementalMode {
-		mode = IncrementalTimeMode
+		mode = ets.IncrementalTimeMode
 	}
 	if *format == "" {
-		if mode == AbsoluteTimeMode {
+		if mode == ets.AbsoluteTimeMode {
 			*format = "[%F %T]"
 		} else {
-			*format = "[%T]"
+			*format = "[%{hours}:%M:%S]"
 		}
 	}
 	timezone := time.Local
@@ -228,37 +312,367 @@ Options:
 		}
 		timezone = location
 	}
-	if *color {
-		*format = "\x1b[32m" + *format + "\x1b[0m"
+	outputFormat := ets.PlainFormat
+	switch *output {
+	case "plain":
+	case "jsonl":
+		outputFormat = ets.JSONLinesFormat
+	case "logfmt":
+		outputFormat = ets.LogfmtFormat
+	default:
+		log.Fatalf("unknown output format %q", *output)
+	}
+	if *color && outputFormat != ets.PlainFormat {
+		log.Fatal("conflicting flags --color and --output")
 	}
 	args := flag.Args()
+	var files []string
+	if *follow {
+		if len(args) == 0 {
+			log.Fatal("--follow requires files")
+		}
+		// Options requiring a command don't apply.
+		files, args = args, nil
+	}
+	if *separateStderr && len(args) == 0 {
+		log.Fatal("--separate-stderr requires a command")
+	}
+	if *noPty && len(args) == 0 {
+		log.Fatal("--no-pty requires a command")
+	}
+	if *timeout != 0 && len(args) == 0 {
+		log.Fatal("--timeout requires a command")
+	}
+	if *killAfter <= 0 {
+		log.Fatalf("invalid --kill-after %s, expected a positive duration", *killAfter)
+	}
+	if *stallTimeout != 0 && len(args) == 0 {
+		log.Fatal("--stall-timeout requires a command")
+	}
+	if (*stallHook != "" || *stallKill) && *stallTimeout == 0 {
+		log.Fatal("--stall-hook and --stall-kill require --stall-timeout")
+	}
+	if (*rusage || *rusageAll) && len(args) == 0 {
+		log.Fatal("--rusage requires a command")
+	}
+	resourceUsage := ets.NoResourceUsage
+	if *rusageAll {
+		resourceUsage = ets.AllResourceUsage
+	} else if *rusage {
+		resourceUsage = ets.CommandResourceUsage
+	}
+	if *sample != 0 && len(args) == 0 {
+		log.Fatal("--sample requires a command")
+	}
+	if *sampleFile != "" && *sample == 0 {
+		log.Fatal("--sample-file requires --sample")
+	}
+	if *multiplex && len(args) == 0 {
+		log.Fatal("--multiplex requires commands")
+	}
+	if flag.CommandLine.Changed("exit-policy") && !*multiplex {
+		log.Fatal("--exit-policy requires --multiplex")
+	}
+	policy := ets.KeepRunning
+	switch *exitPolicy {
+	case "keep":
+	case "stop":
+		policy = ets.StopAll
+	case "first-failure":
+		policy = ets.StopOnFailure
+	default:
+		log.Fatalf("invalid --exit-policy %q, expected keep, stop or first-failure", *exitPolicy)
+	}
+	restartPolicy := ets.NeverRestart
+	switch *restart {
+	case "":
+	case "on-failure":
+		restartPolicy = ets.RestartOnFailure
+	case "always":
+		restartPolicy = ets.RestartAlways
+	default:
+		log.Fatalf("invalid --restart %q, expected on-failure or always", *restart)
+	}
+	if *restart != "" && len(args) == 0 {
+		log.Fatal("--restart requires a command")
+	}
+	if *restartDelay <= 0 {
+		log.Fatalf("invalid --restart-delay %s, expected a positive duration", *restartDelay)
+	}
+	if *restartMaxDelay <= 0 {
+		log.Fatalf("invalid --restart-max-delay %s, expected a positive duration", *restartMaxDelay)
+	}
+	if *restart != "" && *multiplex {
+		log.Fatal("conflicting flags --restart and --multiplex")
+	}
+	if *exitGrace != 0 && len(args) == 0 {
+		log.Fatal("--exit-grace requires a command")
+	}
 
-	timestamper, err := NewTimestamper(*format, mode, timezone)
+	timestamper, err := ets.NewTimestamper(*format, mode, timezone)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	printer := ets.NewPrinter(os.Stdout, timestamper, outputFormat)
+	printer.Color = *color
+	printer.WrapMarker = *wrapMarker
+	printer.HeartbeatsEverywhere = *heartbeatEverywhere
+	var rotatingFile *ets.RotatingFile
+	if *logFile != "" {
+		maxSize, err := parseSize(*logMaxSize)
+		if err != nil {
+			log.Fatal("invalid --log-max-size: ", err)
+		}
+		rotatingFile, err = ets.OpenRotatingFile(*logFile, ets.RotatingFileOptions{
+			MaxSize:  maxSize,
+			MaxAge:   *logRotateInterval,
+			MaxFiles: *logMaxFiles,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		printer.Tee(rotatingFile, ets.TeeOptions{StripANSI: *logStripANSI})
+	}
+
+	var samplePrinter *ets.Printer
+	var sampleOutput *os.File
+	if *sampleFile != "" {
+		sampleOutput, err = os.OpenFile(*sampleFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sampleTimestamper, err := ets.NewTimestamper(*format, mode, timezone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sampleTimestamper.StartTimestamp = timestamper.StartTimestamp
+		samplePrinter = ets.NewPrinter(sampleOutput, sampleTimestamper, outputFormat)
+	}
+
+	streamOptions := ets.StreamOptions{
+		FlushTimeout:  *flushTimeout,
+		MaxLineLength: *maxLineLength,
+	}
+	switch *stampAt {
+	case "end":
+	case "start":
+		streamOptions.StampAtStart = true
+	default:
+		log.Fatalf("invalid --stamp-at %q, expected start or end", *stampAt)
+	}
+
+	forwardedSignals := []syscall.Signal{}
+	if *forwardSignals != "" {
+		for _, name := range strings.Split(*forwardSignals, ",") {
+			sig, err := ets.ParseSignal(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatal("invalid --forward-signals: ", err)
+			}
+			if sig == syscall.SIGKILL || sig == syscall.SIGSTOP {
+				log.Fatalf("invalid --forward-signals: %s cannot be caught", ets.SignalName(sig))
+			}
+			forwardedSignals = append(forwardedSignals, sig)
+		}
+	}
+
+	stopHeartbeats := func() {}
+	if *heartbeat > 0 {
+		stopHeartbeats = printer.StartHeartbeats(*heartbeat)
+	}
+
 	exitCode := 0
-	if len(args) == 0 {
-		printStreamWithTimestamper(os.Stdin, timestamper)
+	if len(files) > 0 {
+		signaled := make(chan os.Signal, 1)
+		signal.Notify(signaled, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+		stop := make(chan struct{})
+		var sig syscall.Signal
+		go func() {
+			sig = (<-signaled).(syscall.Signal)
+			close(stop)
+		}()
+		followOptions := &ets.FollowOptions{StreamOptions: streamOptions}
+		followOptions.Stop = stop
+		err := ets.Follow(files, printer, followOptions)
+		stopHeartbeats()
+		if err != nil {
+			log.Fatal("error following files: ", err)
+		}
+		if *summary {
+			_ = printer.PrintSummary(nil)
+		}
+		// Like a shell would report.
+		exitCode = 128 + int(sig)
+	} else if len(args) == 0 {
+		err := ets.PrintStream(os.Stdin, printer, "", &streamOptions)
+		stopHeartbeats()
+		if err != nil {
+			log.Fatal("error reading stdin: ", err)
+		}
+		if *summary {
+			_ = printer.PrintSummary(nil)
+		}
 	} else {
-		if len(args) == 1 {
-			arg0 := args[0]
-			if matched, _ := regexp.MatchString(`\s`, arg0); matched {
-				shell, err := loginshell.Shell()
-				if err != nil {
-					shell = "sh"
+		shell, err := loginshell.Shell()
+		if err != nil {
+			shell = "sh"
+		}
+		if len(args) == 1 && !*multiplex {
+			args = commandArgs(args[0], shell)
+		}
+		var onStall func(pid int)
+		if *stallHook != "" {
+			onStall = func(pid int) {
+				hook := exec.Command(shell, "-c", *stallHook)
+				hook.Env = append(os.Environ(), "ETS_COMMAND_PID="+strconv.Itoa(pid))
+				hook.Stdout = os.Stderr
+				hook.Stderr = os.Stderr
+				if err := hook.Run(); err != nil {
+					log.Println("stall hook failed:", err)
 				}
-				args = []string{shell, "-c", arg0}
 			}
 		}
-		if err = runCommandWithTimestamper(args, timestamper); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			} else {
+		commandOptions := &ets.CommandOptions{
+			StreamOptions:    streamOptions,
+			Stdin:            os.Stdin,
+			Terminal:         os.Stdin,
+			SeparateStderr:   *separateStderr,
+			ForwardSignals:   true,
+			ForwardedSignals: forwardedSignals,
+			RawTerminal:      true,
+			ExitGracePeriod:  *exitGrace,
+			NoPty:            *noPty,
+			Timeout:          *timeout,
+			KillGracePeriod:  *killAfter,
+			StallTimeout:     *stallTimeout,
+			OnStall:          onStall,
+			KillOnStall:      *stallKill,
+			ResourceUsage:    resourceUsage,
+			SampleInterval:   *sample,
+			SamplePrinter:    samplePrinter,
+		}
+		if *multiplex {
+			commands := make([]ets.LabeledCommand, len(args))
+			for i, arg := range args {
+				commands[i] = labeledCommand(arg, shell)
+			}
+			errs, first := ets.Multiplex(commands, printer, policy, commandOptions)
+			err = nil
+			if first >= 0 {
+				err = errs[first]
+			}
+		} else {
+			err = ets.Supervise(args, printer, &ets.SupervisorOptions{
+				CommandOptions:  *commandOptions,
+				Restart:         restartPolicy,
+				MaxRestarts:     *maxRestarts,
+				RestartDelay:    *restartDelay,
+				MaxRestartDelay: *restartMaxDelay,
+			})
+		}
+		stopHeartbeats()
+		var timeoutErr *ets.TimeoutError
+		var stallErr *ets.StallError
+		timedOut := errors.As(err, &timeoutErr)
+		stalled := errors.As(err, &stallErr)
+		status := ets.ExitStatus{}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			status = ets.NewExitStatus(exitErr.ProcessState)
+		} else if err != nil && !timedOut && !stalled {
+			if !*multiplex {
 				log.Fatal(err)
 			}
+			// Already reported.
+			status.Code = 1
+		}
+		exitCode = status.ShellCode()
+		if status.Signaled() && !*multiplex {
+			_ = printer.PrintEvent("exit", status.String(), status.Data())
+		}
+		if *summary {
+			_ = printer.PrintSummary(&status)
+		}
+		if status.Signaled() && *reraiseSignal && !timedOut && !stalled {
+			if rotatingFile != nil {
+				_ = rotatingFile.Close()
+			}
+			if sampleOutput != nil {
+				_ = sampleOutput.Close()
+			}
+			reraise(status.Signal)
 		}
+		if timedOut {
+			exitCode = timeoutExitCode
+		} else if stalled {
+			exitCode = stallExitCode
+		}
+	}
+	if rotatingFile != nil {
+		_ = rotatingFile.Close()
+	}
+	if sampleOutput != nil {
+		_ = sampleOutput.Close()
 	}
 	os.Exit(exitCode)
 }
+
+// commandArgs returns the arguments to run a command given as a single
+// argument: the shell command with SHELL -c if it contains whitespace, or the
+// command as is.
+func commandArgs(command string, shell string) []string {
+	if matched, _ := regexp.MatchString(`\s`, command); matched {
+		return []string{shell, "-c", command}
+	}
+	return []string{command}
+}
+
+// labeledCommand parses a --multiplex argument of the form [label=]command.
+// The label defaults to the command's first word.
+func labeledCommand(arg string, shell string) ets.LabeledCommand {
+	if m := regexp.MustCompile(`^([\w.-]+)=(.*)$`).FindStringSubmatch(arg); m != nil {
+		return ets.LabeledCommand{Label: m[1], Args: commandArgs(m[2], shell)}
+	}
+	label := arg
+	if fields := strings.Fields(arg); len(fields) > 0 {
+		label = path.Base(fields[0])
+	}
+	return ets.LabeledCommand{Label: label, Args: commandArgs(arg, shell)}
+}
+
+// defaultForwardedSignals returns the default value of --forward-signals.
+func defaultForwardedSignals() string {
+	names := make([]string, len(ets.DefaultForwardedSignals))
+	for i, sig := range ets.DefaultForwardedSignals {
+		names[i] = strings.TrimPrefix(ets.SignalName(sig), "SIG")
+	}
+	return strings.Join(names, ",")
+}
+
+// parseSize parses a size in bytes, optionally suffixed with K, M or G (powers
+// of 1024). The empty string is parsed as 0.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		multiplier = 1 << 10
+	case 'M', 'm':
+		multiplier = 1 << 20
+	case 'G', 'g':
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size %d", n)
+	}
+	return n * multiplier, nil
+}
@@ -1,6 +1,10 @@
 package main_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -11,9 +15,11 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/creack/pty"
 )
@@ -201,6 +207,125 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+func TestSeparateStderr(t *testing.T) {
+	cmd := exec.Command("./ets", "-e", "-f", "[%{stream}]", "./basic")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	parsed := parseOutput(output, `\[(?P<stream>stdout|stderr)\]`)
+	stdoutOutputs := make([]string, 0)
+	stderrOutputs := make([]string, 0)
+	for _, pl := range parsed {
+		switch pl.captures["stream"] {
+		case "stdout":
+			stdoutOutputs = append(stdoutOutputs, pl.output)
+		case "stderr":
+			stderrOutputs = append(stderrOutputs, pl.output)
+		default:
+			t.Errorf("unexpected line: %s", pl.raw)
+		}
+	}
+	if expected := []string{"out1", "out2", "out3"}; !reflect.DeepEqual(stdoutOutputs, expected) {
+		t.Errorf("wrong stdout outputs: expected %#v, got %#v", expected, stdoutOutputs)
+	}
+	if expected := []string{"err1", "err2", "err3"}; !reflect.DeepEqual(stderrOutputs, expected) {
+		t.Errorf("wrong stderr outputs: expected %#v, got %#v", expected, stderrOutputs)
+	}
+}
+
+func TestNoPty(t *testing.T) {
+	t.Run("streams", func(t *testing.T) {
+		cmd := exec.Command("./ets", "--no-pty", "-f", "[%{stream}]", "./basic")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("command failed: %s", err)
+		}
+		parsed := parseOutput(output, `\[(?P<stream>stdout|stderr)\]`)
+		outputs := map[string][]string{}
+		for _, pl := range parsed {
+			if pl.prefix == "" {
+				t.Errorf("unexpected line: %s", pl.raw)
+			}
+			outputs[pl.captures["stream"]] = append(outputs[pl.captures["stream"]], pl.output)
+		}
+		expectedOutputs := map[string][]string{
+			"stdout": {"out1", "out2", "out3"},
+			"stderr": {"err1", "err2", "err3"},
+		}
+		if !reflect.DeepEqual(outputs, expectedOutputs) {
+			t.Errorf("wrong outputs: expected %#v, got %#v", expectedOutputs, outputs)
+		}
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		cmd := exec.Command("./ets", "--no-pty", "-f", "[timestamp]", "./detect_tty")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("command failed: %s", err)
+		}
+		if expectedOutput := "[timestamp] pipe\n"; string(output) != expectedOutput {
+			t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+		}
+	})
+
+	t.Run("stdin-and-exit-code", func(t *testing.T) {
+		cmd := exec.Command("./ets", "--no-pty", "-f", "[timestamp]", "sh", "-c", "cat; exit 3")
+		cmd.Stdin = strings.NewReader("input\n")
+		output, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+			t.Errorf("expected exit code 3, got %v", err)
+		}
+		if expectedOutput := "[timestamp] input\n"; string(output) != expectedOutput {
+			t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+		}
+	})
+
+	t.Run("signals", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("skipping slow test in short mode")
+		}
+		cmd := exec.Command("./ets", "--no-pty", "-f", "[timestamp]", "./signals")
+		go func() {
+			time.Sleep(time.Second)
+			_ = cmd.Process.Signal(syscall.SIGINT)
+			time.Sleep(500 * time.Millisecond)
+			_ = cmd.Process.Signal(syscall.SIGTERM)
+		}()
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("command failed: %s", err)
+		}
+		for _, expected := range []string{"[timestamp] ignored SIGINT\n", "[timestamp] shutting down after receiving SIGTERM\n"} {
+			if !strings.Contains(string(output), expected) {
+				t.Errorf("expected output %#v not found in %#v", expected, string(output))
+			}
+		}
+	})
+}
+
+func TestLogFile(t *testing.T) {
+	logFile := path.Join(tempdir, "test.log")
+	defer os.Remove(logFile)
+	cmd := exec.Command("./ets", "-c", "--log-file", logFile, "--log-strip-ansi", "-f", "[timestamp]", "printf '\x1b[1mbold\x1b[0m\n'")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput := "\x1b[32m[timestamp]\x1b[0m \x1b[1mbold\x1b[0m\n"
+	if string(output) != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+	content, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedContent := "[timestamp] bold\n"
+	if string(content) != expectedContent {
+		t.Errorf("wrong log file content: expected %#v, got %#v", expectedContent, string(content))
+	}
+}
+
 func TestCR(t *testing.T) {
 	cmd := exec.Command("./ets", "-f", "[timestamp]", "echo '1\r2'")
 	expectedOutput := "[timestamp] 1\r[timestamp] 2\n"
@@ -213,6 +338,108 @@ func TestCR(t *testing.T) {
 	}
 }
 
+func TestJSONLinesOutput(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "-o", "jsonl", "./basic")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutputs := []string{"out1", "err1", "out2", "err2", "out3", "err3"}
+	outputs := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		var obj struct {
+			Timestamp  string `json:"timestamp"`
+			Line       string `json:"line"`
+			Terminator string `json:"terminator"`
+		}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("failed to parse line %#v: %s", line, err)
+		}
+		if obj.Timestamp != "[00:00:00]" || obj.Terminator != "LF" {
+			t.Errorf("unexpected line: %s", line)
+		}
+		outputs = append(outputs, obj.Line)
+	}
+	if !reflect.DeepEqual(outputs, expectedOutputs) {
+		t.Fatalf("wrong outputs: expected %#v, got %#v", expectedOutputs, outputs)
+	}
+}
+
+func TestLogfmtOutput(t *testing.T) {
+	cmd := exec.Command("./ets", "-o", "logfmt", `echo 'hello "world"'`)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	pattern := regexp.MustCompile(`^ts=\d{4}-\d{2}-\d{2}T\S+ elapsed=\S+s msg="hello \\"world\\""\n$`)
+	if !pattern.Match(output) {
+		t.Fatalf("wrong output: %#v", string(output))
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	cmd := exec.Command("./ets", "--flush-timeout", "100ms", "-o", "jsonl", "printf 'prompt: '; sleep 0.5; echo answer")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	type record struct {
+		Line      string `json:"line"`
+		Partial   bool   `json:"partial"`
+		Continued bool   `json:"continued"`
+	}
+	expectedRecords := []record{
+		{Line: "prompt: ", Partial: true},
+		{Line: "answer", Continued: true},
+	}
+	records := make([]record, 0)
+	for _, line := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		var r record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("failed to parse line %#v: %s", line, err)
+		}
+		records = append(records, r)
+	}
+	if !reflect.DeepEqual(records, expectedRecords) {
+		t.Fatalf("wrong records: expected %#v, got %#v", expectedRecords, records)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	input := strings.Repeat("x", 100000) + "\nafter\n"
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+	}{
+		{
+			"unlimited",
+			[]string{"-f", "[timestamp]"},
+			"[timestamp] " + strings.Repeat("x", 100000) + "\n[timestamp] after\n",
+		},
+		{
+			"wrapped",
+			[]string{"-f", "[timestamp]", "--max-line-length", "40000", "--wrap-marker", "+"},
+			"[timestamp] " + strings.Repeat("x", 40000) + "+\n" +
+				"[timestamp] " + strings.Repeat("x", 40000) + "+\n" +
+				"[timestamp] " + strings.Repeat("x", 20000) + "\n[timestamp] after\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command("./ets", test.args...)
+			cmd.Stdin = strings.NewReader(input)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("command failed: %s", err)
+			}
+			if string(output) != test.expectedOutput {
+				t.Fatalf("wrong output of length %d, expected length %d", len(output), len(test.expectedOutput))
+			}
+		})
+	}
+}
+
 func TestStdin(t *testing.T) {
 	input := "out1\nout2\nout3\n"
 	expectedOutputs := []string{"out1", "out2", "out3"}
@@ -269,6 +496,31 @@ func TestIncrementalMode(t *testing.T) {
 	}
 }
 
+func TestStampAtStart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	for _, stdin := range []bool{false, true} {
+		t.Run("stdin-"+strconv.FormatBool(stdin), func(t *testing.T) {
+			expectedOutput := "[0] slow line\n[1] next\n"
+			shellCommand := "printf 'slow '; sleep 1; echo line; sleep 0.5; echo next"
+			var cmd *exec.Cmd
+			if stdin {
+				cmd = exec.Command("sh", "-c", "("+shellCommand+") | ./ets -s -f '[%s]' --stamp-at start")
+			} else {
+				cmd = exec.Command("./ets", "-s", "-f", "[%s]", "--stamp-at", "start", shellCommand)
+			}
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("command failed: %s", err)
+			}
+			if string(output) != expectedOutput {
+				t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+			}
+		})
+	}
+}
+
 func TestExitCode(t *testing.T) {
 	for code := 1; code < 6; code++ {
 		t.Run("exitcode-"+strconv.Itoa(code), func(t *testing.T) {
@@ -285,6 +537,42 @@ func TestExitCode(t *testing.T) {
 	}
 }
 
+func TestSignalExitCode(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[timestamp]", "kill -USR1 $$")
+	output, err := cmd.Output()
+	errExit, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("expected ExitError, got %#v", err)
+	}
+	if errExit.ExitCode() != 128+int(syscall.SIGUSR1) {
+		t.Errorf("expected exit code %d, got %d", 128+int(syscall.SIGUSR1), errExit.ExitCode())
+	}
+	expectedOutput := "[timestamp] terminated by SIGUSR1\n"
+	if string(output) != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+}
+
+func TestReraise(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGUSR1} {
+		t.Run(sig.String(), func(t *testing.T) {
+			if sig != syscall.SIGTERM && runtime.GOOS != "linux" {
+				t.Skip("only signals the Go runtime dies from can be reraised on this platform")
+			}
+			cmd := exec.Command("./ets", "--reraise", "kill -"+strconv.Itoa(int(sig))+" $$")
+			err := cmd.Run()
+			errExit, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Fatalf("expected ExitError, got %#v", err)
+			}
+			ws := errExit.Sys().(syscall.WaitStatus)
+			if !ws.Signaled() || ws.Signal() != sig {
+				t.Fatalf("expected termination by %s, got %#v", sig, ws)
+			}
+		})
+	}
+}
+
 func TestSignals(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping slow test in short mode")
@@ -292,7 +580,10 @@ func TestSignals(t *testing.T) {
 	cmd := exec.Command("./ets", "./signals")
 	go func() {
 		time.Sleep(time.Second)
-		_ = cmd.Process.Signal(syscall.SIGINT)
+		for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGALRM} {
+			_ = cmd.Process.Signal(sig)
+			time.Sleep(100 * time.Millisecond)
+		}
 		time.Sleep(time.Second)
 		_ = cmd.Process.Signal(syscall.SIGTERM)
 	}()
@@ -310,7 +601,12 @@ func TestSignals(t *testing.T) {
 	}
 	for _, expectedOutput := range []string{
 		"busy waiting",
+		"ignored SIGHUP",
 		"ignored SIGINT",
+		"ignored SIGQUIT",
+		"ignored SIGUSR1",
+		"ignored SIGUSR2",
+		"ignored SIGALRM",
 		"shutting down after receiving SIGTERM",
 	} {
 		found := false
@@ -326,6 +622,536 @@ func TestSignals(t *testing.T) {
 	}
 }
 
+func TestForwardSignalsOption(t *testing.T) {
+	for _, value := range []string{"KILL", "HUP,bogus"} {
+		cmd := exec.Command("./ets", "--forward-signals="+value, "true")
+		if err := cmd.Run(); err == nil {
+			t.Errorf("--forward-signals=%s: expected error", value)
+		}
+	}
+
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	// SIGINT isn't forwarded, so it terminates ets itself. The command is left
+	// running, so it's killed afterwards by its pid, which it prints first.
+	cmd := exec.Command("./ets", "-f", "[timestamp]", "--forward-signals=TERM", "echo $$; exec ./signals")
+	go func() {
+		time.Sleep(time.Second)
+		_ = cmd.Process.Signal(syscall.SIGINT)
+	}()
+	output, err := cmd.Output()
+	if parsed := parseOutput(output, `\[timestamp\]`); len(parsed) > 0 {
+		if pid, err := strconv.Atoi(parsed[0].output); err == nil {
+			// The command leads its own process group.
+			_ = syscall.Kill(-pid, syscall.SIGKILL)
+		} else {
+			t.Errorf("expected the command's pid, got %q", parsed[0].raw)
+		}
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("expected ets to be terminated, got %v", err)
+	}
+	if status := exitErr.Sys().(syscall.WaitStatus); !status.Signaled() || status.Signal() != syscall.SIGINT {
+		t.Errorf("expected ets to be terminated by SIGINT, got %v", exitErr)
+	}
+	if strings.Contains(string(output), "ignored SIGINT") {
+		t.Errorf("SIGINT unexpectedly forwarded: %q", output)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	for _, value := range []string{"0", "-1s"} {
+		cmd := exec.Command("./ets", "--timeout", "1s", "--kill-after", value, "true")
+		if err := cmd.Run(); err == nil {
+			t.Errorf("--kill-after %s: expected error", value)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		args            []string
+		expectedOutputs []string
+	}{
+		{
+			"terminated",
+			[]string{"--timeout", "500ms", "--kill-after", "2s", "./signals"},
+			[]string{
+				"timed out after 500ms, sending SIGTERM",
+				"shutting down after receiving SIGTERM",
+			},
+		},
+		{
+			"killed",
+			[]string{"--timeout", "500ms", "--kill-after", "500ms", "sh", "-c", `trap "" TERM; echo started; sleep 10`},
+			[]string{
+				"started",
+				"timed out after 500ms, sending SIGTERM",
+				"still running 500ms after SIGTERM, sending SIGKILL",
+				"terminated by SIGKILL",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command("./ets", append([]string{"-f", "[timestamp]"}, test.args...)...)
+			output, err := cmd.Output()
+			if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 124 {
+				t.Errorf("expected exit code 124, got %v", err)
+			}
+			parsed := parseOutput(output, `\[timestamp\]`)
+			outputs := make([]string, 0)
+			for _, pl := range parsed {
+				if pl.prefix == "" {
+					t.Errorf("unexpected line: %s", pl.raw)
+				}
+				if pl.output != "busy waiting" {
+					outputs = append(outputs, pl.output)
+				}
+			}
+			if !reflect.DeepEqual(outputs, test.expectedOutputs) {
+				t.Errorf("wrong outputs: expected %#v, got %#v", test.expectedOutputs, outputs)
+			}
+		})
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	t.Run("warn", func(t *testing.T) {
+		cmd := exec.Command("./ets", "-f", "[timestamp]", "--stall-timeout", "300ms", "--stall-hook", "echo hook $ETS_COMMAND_PID", "sh", "-c", "echo 1; sleep 0.5; echo 2; sleep 0.1; echo 3; sleep 0.8; echo 4")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("command failed: %s", err)
+		}
+		// Warned once per stall.
+		expectedOutput := "[timestamp] 1\n[timestamp] no output for 300ms\n[timestamp] 2\n[timestamp] 3\n[timestamp] no output for 300ms\n[timestamp] 4\n"
+		if string(output) != expectedOutput {
+			t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+		}
+		if hookOutput := stderr.String(); !regexp.MustCompile(`^(hook \d+\n){2}$`).MatchString(hookOutput) {
+			t.Errorf("wrong hook output: %#v", hookOutput)
+		}
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		cmd := exec.Command("./ets", "-f", "[timestamp]", "--stall-timeout", "300ms", "--stall-kill", "sh", "-c", "echo 1; sleep 10")
+		output, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 125 {
+			t.Errorf("expected exit code 125, got %v", err)
+		}
+		expectedOutput := "[timestamp] 1\n[timestamp] no output for 300ms, sending SIGTERM\n[timestamp] terminated by SIGTERM\n"
+		if string(output) != expectedOutput {
+			t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+		}
+	})
+}
+
+func TestHeartbeat(t *testing.T) {
+	logFile := path.Join(tempdir, "heartbeat.log")
+	defer os.Remove(logFile)
+	cmd := exec.Command("./ets", "--heartbeat", "300ms", "--log-file", logFile, "-f", "[timestamp]", "sleep 0.5; echo done")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput := "[timestamp] … still running (elapsed 00:00:00)\n[timestamp] done\n"
+	if string(output) != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+	content, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expectedContent := "[timestamp] done\n"; string(content) != expectedContent {
+		t.Errorf("wrong log file content: expected %#v, got %#v", expectedContent, string(content))
+	}
+}
+
+func TestSummary(t *testing.T) {
+	cmd := exec.Command("./ets", "--summary", "-f", "[timestamp]", "echo 1; sleep 0.3; echo 22; exit 3")
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("expected exit code 3, got %v", err)
+	}
+	pattern := regexp.MustCompile(`^\[timestamp\] 1\n\[timestamp\] 22\n\[timestamp\] started .*, elapsed \S+, 2 lines, 5 bytes, longest gap 3\d\dms before "22", exited with status 3\n$`)
+	if !pattern.Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestRusage(t *testing.T) {
+	cmd := exec.Command("./ets", "-o", "jsonl", "--rusage", "./basic")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	var obj struct {
+		Event string `json:"event"`
+		Data  struct {
+			UserTime *int64 `json:"user_time"`
+			MaxRSS   int64  `json:"max_rss"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &obj); err != nil {
+		t.Fatalf("failed to parse line %#v: %s", lines[len(lines)-1], err)
+	}
+	if obj.Event != "rusage" || obj.Data.UserTime == nil || obj.Data.MaxRSS <= 0 {
+		t.Errorf("wrong rusage event: %s", lines[len(lines)-1])
+	}
+}
+
+func TestSample(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sampling is only supported on Linux")
+	}
+	cmd := exec.Command("./ets", "-o", "jsonl", "--sample", "200ms", "sh", "-c", "sleep 1 & sleep 1; wait; echo done")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	samples := 0
+	maxProcesses := 0
+	for _, l := range lines {
+		var obj struct {
+			Event string `json:"event"`
+			Data  struct {
+				CPUPercent *float64 `json:"cpu_percent"`
+				RSS        int64    `json:"rss"`
+				Processes  int      `json:"processes"`
+				Threads    int      `json:"threads"`
+				FDs        int      `json:"fds"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(l), &obj); err != nil {
+			t.Fatalf("failed to parse line %#v: %s", l, err)
+		}
+		if obj.Event != "sample" {
+			continue
+		}
+		samples++
+		if obj.Data.CPUPercent == nil || obj.Data.RSS <= 0 || obj.Data.Processes < 1 || obj.Data.Threads < obj.Data.Processes || obj.Data.FDs <= 0 {
+			t.Errorf("wrong sample event: %s", l)
+		}
+		if obj.Data.Processes > maxProcesses {
+			maxProcesses = obj.Data.Processes
+		}
+	}
+	if samples < 3 {
+		t.Errorf("expected at least 3 samples, got %d in %#v", samples, string(output))
+	}
+	// sh and both sleeps.
+	if maxProcesses != 3 {
+		t.Errorf("expected samples of 3 processes, got at most %d", maxProcesses)
+	}
+	if !strings.Contains(lines[len(lines)-1], `"line":"done"`) {
+		t.Errorf("sample printed after the command exited: %s", lines[len(lines)-1])
+	}
+}
+
+func TestSampleFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sampling is only supported on Linux")
+	}
+	sampleFile := path.Join(tempdir, "samples.log")
+	cmd := exec.Command("./ets", "-f", "[ts]", "--sample", "100ms", "--sample-file", sampleFile, "sh", "-c", "sleep 0.5; echo done")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	if expected := "[ts] done\n"; string(output) != expected {
+		t.Errorf("wrong output: expected %#v, got %#v", expected, string(output))
+	}
+	samples, err := ioutil.ReadFile(sampleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := regexp.MustCompile(`^(\[ts\] CPU \d+\.\d%, RSS \d+\.\d MiB, \d+ processes, \d+ threads, \d+ fds\n)+$`)
+	if !pattern.Match(samples) {
+		t.Errorf("wrong samples: %#v", string(samples))
+	}
+}
+
+func TestMultiplex(t *testing.T) {
+	cmd := exec.Command("./ets", "-m", "-f", "[ts]", "--exit-policy", "first-failure", "api=sleep 0.5; echo api", "./basic", "worker=sleep 0.2; exit 2")
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+		t.Fatalf("expected exit status 2, got %v", err)
+	}
+	expectedOutput := "[ts] basic  | out1\n[ts] basic  | err1\n[ts] basic  | out2\n[ts] basic  | err2\n[ts] basic  | out3\n[ts] basic  | err3\n" +
+		"[ts] basic  | exited with status 0\n" +
+		"[ts] worker | exited with status 2\n" +
+		"[ts] stopping the other commands since worker exited with status 2\n" +
+		"[ts] api    | terminated by SIGTERM\n"
+	if string(output) != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+}
+
+func TestRestart(t *testing.T) {
+	for _, flag := range []string{"--restart-delay", "--restart-max-delay"} {
+		cmd := exec.Command("./ets", "--restart", "always", flag, "0", "true")
+		if err := cmd.Run(); err == nil {
+			t.Errorf("%s 0: expected error", flag)
+		}
+	}
+
+	cmd := exec.Command("./ets", "-f", "[%{restart}]", "--restart", "on-failure", "--restart-delay", "50ms", "--max-restarts", "2", "./basic", "-exitcode", "3")
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	run := func(n string) string {
+		return strings.ReplaceAll("[n] out1\n[n] err1\n[n] out2\n[n] err2\n[n] out3\n[n] err3\n", "n", n)
+	}
+	expectedOutput := run("0") +
+		"[1] restarting (attempt 1) after exit 3\n" + run("1") +
+		"[2] restarting (attempt 2) after exit 3\n" + run("2") +
+		"[2] not restarting after exit 3: limit of 2 restarts reached\n"
+	if string(output) != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+}
+
+func TestRestartInterrupted(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[%{restart}]", "--restart", "always", "--restart-delay", "10ms", "sh", "-c", "echo run; sleep 5")
+	var output safeBuffer
+	cmd.Stdout = &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGINT)
+	err := cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 130 {
+		t.Fatalf("expected exit status 130, got %v", err)
+	}
+	expectedOutput := "[0] run\n[0] terminated by SIGINT\n"
+	if output.String() != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, output.String())
+	}
+}
+
+func TestFollow(t *testing.T) {
+	logFile := path.Join(tempdir, "follow.log")
+	if err := ioutil.WriteFile(logFile, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("./ets", "-F", "-f", "[ts]", "--summary", logFile)
+	var output safeBuffer
+	cmd.Stdout = &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("new\n")
+	_ = f.Close()
+	time.Sleep(500 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGINT)
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 130 {
+		t.Fatalf("expected exit status 130, got %v", err)
+	}
+	if !regexp.MustCompile(`^\[ts\] new\n\[ts\] started .*, 1 lines, 4 bytes, .*\n$`).MatchString(output.String()) {
+		t.Errorf("wrong output %#v", output.String())
+	}
+}
+
+func TestFollowRequiresFiles(t *testing.T) {
+	for _, args := range [][]string{{"-F"}, {"-F", "--timeout", "1s", "file"}} {
+		cmd := exec.Command("./ets", args...)
+		if output, err := cmd.CombinedOutput(); err == nil {
+			t.Errorf("expected %v to fail, got %#v", args, string(output))
+		}
+	}
+}
+
+func TestExitGrace(t *testing.T) {
+	// The background process inherits the stderr pty and holds it open after
+	// the command exits.
+	start := time.Now()
+	cmd := exec.Command("./ets", "-e", "--exit-grace", "500ms", "-f", "[%{stream}]", "sh", "-c", `(trap "" HUP; sleep 3; echo late >&2) & sleep 0.3; echo hi`)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("ets took %s to exit", elapsed)
+	}
+	expectedOutput := "[stdout] hi\n[] stopped reading output 500ms after the command exited, 0 bytes left unread\n"
+	if string(output) != expectedOutput {
+		t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+}
+
+func TestJobControl(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	cmd := exec.Command("./ets", "./signals")
+	var output safeBuffer
+	cmd.Stdout = &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	_ = cmd.Process.Signal(syscall.SIGTSTP)
+	time.Sleep(500 * time.Millisecond)
+	if runtime.GOOS == "linux" {
+		pids := []string{strconv.Itoa(cmd.Process.Pid)}
+		// Children are listed by the thread that forked them.
+		tasks, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", cmd.Process.Pid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			children, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%s/children", cmd.Process.Pid, task.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pids = append(pids, strings.Fields(string(children))...)
+		}
+		if len(pids) != 2 {
+			t.Fatalf("expected a single child process, got %v", pids[1:])
+		}
+		for _, pid := range pids {
+			stat, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields := strings.Fields(string(stat)); fields[2] != "T" {
+				t.Errorf("expected process %s %s to be stopped, got state %s", pid, fields[1], fields[2])
+			}
+		}
+	}
+	suspendedLen := output.Len()
+	time.Sleep(time.Second)
+	if output.Len() != suspendedLen {
+		t.Errorf("unexpected output while suspended: %q", output.String()[suspendedLen:])
+	}
+	_ = cmd.Process.Signal(syscall.SIGCONT)
+	time.Sleep(time.Second)
+	if output.Len() == suspendedLen {
+		t.Errorf("no output after resuming")
+	}
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	if !strings.Contains(output.String(), "shutting down after receiving SIGTERM") {
+		t.Errorf("command not shut down properly: %q", output.String())
+	}
+}
+
+func TestRawTerminal(t *testing.T) {
+	// startInPty starts ets with the given args with a new pty as its
+	// terminal, returning the pty master and the pty's output so far.
+	startInPty := func(t *testing.T, args ...string) (*exec.Cmd, *os.File, *safeBuffer) {
+		ptmx, tty, err := pty.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = ptmx.Close()
+			_ = tty.Close()
+		})
+		output := &safeBuffer{}
+		go func() { _, _ = io.Copy(output, ptmx) }()
+		cmd := exec.Command("./ets", args...)
+		cmd.Stdin = tty
+		cmd.Stdout = tty
+		cmd.Stderr = tty
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// Wait for the terminal to be switched to raw mode.
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			var termios syscall.Termios
+			_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&termios)))
+			if errno == 0 && termios.Lflag&(syscall.ICANON|syscall.ECHO) == 0 {
+				break
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("terminal not switched to raw mode")
+			}
+		}
+		return cmd, ptmx, output
+	}
+	// waitForOutput waits for the output to be expectedOutput, or gives up
+	// after a while.
+	waitForOutput := func(output *safeBuffer, expectedOutput string) {
+		for start := time.Now(); output.String() != expectedOutput && time.Since(start) < 2*time.Second; {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// checkRestored checks that the terminal is back in cooked mode, echoing
+	// input.
+	checkRestored := func(t *testing.T, ptmx *os.File, output *safeBuffer) {
+		_, _ = ptmx.Write([]byte("x\r"))
+		time.Sleep(100 * time.Millisecond)
+		if !strings.HasSuffix(output.String(), "x\r\n") {
+			t.Errorf("terminal not restored: got %#v", output.String())
+		}
+	}
+
+	t.Run("input", func(t *testing.T) {
+		cmd, ptmx, output := startInPty(t, "-f", "[timestamp]", "head", "-n1")
+		_, _ = ptmx.Write([]byte("hello\r"))
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("command failed: %s", err)
+		}
+		// Input is echoed by the command's pty only, not by the outer terminal.
+		expectedOutput := "[timestamp] hello\r\n[timestamp] hello\r\n"
+		waitForOutput(output, expectedOutput)
+		if output.String() != expectedOutput {
+			t.Errorf("wrong output: expected %#v, got %#v", expectedOutput, output.String())
+		}
+		checkRestored(t, ptmx, output)
+	})
+
+	t.Run("signal", func(t *testing.T) {
+		cmd, ptmx, output := startInPty(t, "--forward-signals=", "sleep", "10")
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		err := cmd.Wait()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+			t.Fatalf("expected ets to be terminated by SIGTERM, got %v", err)
+		}
+		checkRestored(t, ptmx, output)
+	})
+}
+
+// safeBuffer is a bytes.Buffer safe for concurrent use.
+type safeBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *safeBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 func TestWindowSize(t *testing.T) {
 	tests := []struct {
 		name           string
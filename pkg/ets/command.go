@@ -0,0 +1,631 @@
+package ets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/creack/pty"
+)
+
+// Regexp to strip ANSI escape sequences from string. Credit:
+// https://github.com/chalk/ansi-regex/blob/2b56fb0c7a07108e5b54241e8faec160d393aedb/index.js#L4-L7
+// https://github.com/acarl005/stripansi/blob/5a71ef0e047df0427e87a79f27009029921f1f9b/stripansi.go#L7
+var ansiEscapes = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
+
+// CommandOptions controls how RunCommand wires up the command.
+type CommandOptions struct {
+	// StreamOptions controls how the command's output is read.
+	StreamOptions
+
+	// Stdin is copied to the command's pty. Nil means no input.
+	Stdin io.Reader
+	// Terminal, if set, is the terminal whose size is mirrored onto the
+	// command's pty (minus the width taken up by timestamps).
+	Terminal *os.File
+	// SeparateStderr attaches the command's stderr to a pty of its own, so
+	// that lines from stdout and stderr can be told apart. Otherwise both
+	// streams share a pty and lines aren't attributed to either.
+	SeparateStderr bool
+	// ForwardSignals installs handlers for SIGWINCH (pty resizing),
+	// ForwardedSignals, and SIGTSTP and SIGCONT (job control) for the
+	// duration of the command. On SIGTSTP, the command's process group is
+	// stopped before the current process stops itself; on SIGCONT, the
+	// command is continued and its pty resized to the terminal's current
+	// size, which may have changed in the meantime.
+	ForwardSignals bool
+	// ForwardedSignals are the signals relayed to the command's process group
+	// when ForwardSignals is set. Nil means DefaultForwardedSignals.
+	ForwardedSignals []syscall.Signal
+	// RawTerminal switches Terminal, if a tty, to raw mode for the duration
+	// of the command, so that input is passed through to the command as is,
+	// to be edited and echoed by the command's pty rather than twice. The
+	// terminal is restored when the command exits, when the current process
+	// is suspended, and before dying from SIGHUP, SIGINT, SIGQUIT or SIGTERM
+	// if not forwarded.
+	RawTerminal bool
+	// ExitGracePeriod, if positive, limits how long the command's output is
+	// read after the command exits. Background processes started by the
+	// command may inherit its pty and keep it open long after, e.g. a daemon
+	// holding on to the stderr pty with SeparateStderr. Once the grace period
+	// is over, reading stops, and the number of bytes left unread in the pty
+	// (or pipes, with NoPty) is reported with an "unread" event.
+	ExitGracePeriod time.Duration
+	// NoPty runs the command with pipes rather than a pty, so that it
+	// doesn't see a terminal, e.g. doesn't print colors or progress bars.
+	// stdout and stderr get a pipe each, and lines are attributed to either.
+	// The command runs in a process group of its own. Terminal and
+	// RawTerminal have no effect.
+	NoPty bool
+	// Timeout, if positive, limits how long the command may run. Once
+	// exceeded, SIGTERM is sent to the command's process group, followed by
+	// SIGKILL if the command is still running KillGracePeriod later. Both
+	// are announced with a "timeout" event.
+	Timeout time.Duration
+	// KillGracePeriod is how long to wait for the command to exit after
+	// SIGTERM on timeout or stall before resorting to SIGKILL. Zero means
+	// DefaultKillGracePeriod.
+	KillGracePeriod time.Duration
+	// StallTimeout, if positive, is how long the command may go without
+	// printing a line before it is considered stalled. A stall is announced
+	// with a "stall" event, once until the command prints again.
+	StallTimeout time.Duration
+	// OnStall, if set, is called with the command's pid when it stalls, e.g.
+	// to dump stack traces. The command isn't killed before OnStall returns.
+	OnStall func(pid int)
+	// KillOnStall terminates the command when it stalls, like on timeout.
+	KillOnStall bool
+	// ResourceUsage selects the resource usage reported with an "rusage"
+	// event once the command exits, if any.
+	ResourceUsage ResourceUsageReport
+	// SampleInterval, if positive, is how often the resource usage of the
+	// command and its descendants is sampled (see SampleProcessTree) while
+	// it runs and printed with a "sample" event. Only supported on Linux.
+	SampleInterval time.Duration
+	// SamplePrinter, if set, prints the "sample" events instead of the
+	// command's printer, e.g. to keep them out of the command's output.
+	SamplePrinter *Printer
+
+	// Used by Multiplex: if cancel is closed, the command is terminated like
+	// on timeout; onStart is called with the command's pid once started;
+	// noJobControl leaves SIGTSTP and SIGCONT to the caller.
+	cancel       <-chan struct{}
+	onStart      func(pid int)
+	noJobControl bool
+	// Used by Supervise: Stdin is copied by stdinRelay, which outlives the
+	// command, rather than by the command itself.
+	stdinRelay *stdinRelay
+}
+
+// ResourceUsageReport selects the resource usage reported by RunCommand.
+type ResourceUsageReport int
+
+const (
+	// NoResourceUsage reports nothing.
+	NoResourceUsage ResourceUsageReport = iota
+	// CommandResourceUsage reports the resource usage of the command, which
+	// includes that of its descendants it waited for, as with time(1).
+	CommandResourceUsage
+	// AllResourceUsage reports the total resource usage of all descendants
+	// of the current process waited for so far (see ChildrenResourceUsage),
+	// including e.g. previous commands.
+	AllResourceUsage
+)
+
+// TimeoutError is returned by RunCommand when the command is terminated for
+// exceeding CommandOptions.Timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	// Err is the error returned by (*exec.Cmd).Wait, if any.
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// StallError is returned by RunCommand when the command is terminated for
+// stalling, with CommandOptions.KillOnStall.
+type StallError struct {
+	StallTimeout time.Duration
+	// Err is the error returned by (*exec.Cmd).Wait, if any.
+	Err error
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("no output for %s", e.StallTimeout)
+}
+
+func (e *StallError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultKillGracePeriod is the default CommandOptions.KillGracePeriod.
+const DefaultKillGracePeriod = 10 * time.Second
+
+// DefaultForwardedSignals are the signals forwarded to a command by default:
+// those commonly sent by users and process supervisors.
+var DefaultForwardedSignals = []syscall.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGALRM,
+}
+
+// RunCommand executes args in a pty (or with pipes, see
+// CommandOptions.NoPty) and prints its output with printer. The
+// returned error is that of (*exec.Cmd).Wait, wrapped in a *TimeoutError if
+// the command timed out, or an error starting the command.
+func RunCommand(args []string, printer *Printer, opts *CommandOptions) error {
+	if opts == nil {
+		opts = &CommandOptions{}
+	}
+
+	// Calculate optimal pty size, taking into account horizontal space taken up by timestamps.
+	getPtyWinsize := func() *pty.Winsize {
+		if opts.Terminal == nil {
+			return nil
+		}
+		winsize, err := pty.GetsizeFull(opts.Terminal)
+		if err != nil {
+			// Most likely the terminal isn't a tty, in which case we don't care.
+			return winsize
+		}
+		totalCols := winsize.Cols
+		occupiedWidth := uint16(printer.PrefixWidth())
+		var effectiveCols uint16 = 0
+		if occupiedWidth < totalCols {
+			effectiveCols = totalCols - occupiedWidth
+		}
+		winsize.Cols = effectiveCols
+		// Best effort estimate of the effective width in pixels.
+		if totalCols > 0 {
+			winsize.X = winsize.X * effectiveCols / totalCols
+		}
+		return winsize
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	// Files the command's output is read from: pty masters, or the read ends
+	// of pipes with NoPty. stderr is nil if stderr shares stdout's pty.
+	var stdout, stderr *os.File
+	// Where input is copied to.
+	var stdin *os.File
+	if opts.NoPty {
+		var err error
+		stdout, stderr, stdin, err = startWithPipes(command, opts.Stdin != nil)
+		if err != nil {
+			return err
+		}
+		if stdin != nil {
+			defer func() { _ = stdin.Close() }()
+		}
+	} else {
+		var stderrTty *os.File
+		if opts.SeparateStderr {
+			var err error
+			stderr, stderrTty, err = pty.Open()
+			if err != nil {
+				return err
+			}
+			if winsize := getPtyWinsize(); winsize != nil {
+				_ = pty.Setsize(stderr, winsize)
+			}
+			command.Stderr = stderrTty
+		}
+		ptmx, err := pty.StartWithSize(command, getPtyWinsize())
+		if stderrTty != nil {
+			// The command holds its own copy from now on.
+			_ = stderrTty.Close()
+		}
+		if err != nil {
+			if stderr != nil {
+				_ = stderr.Close()
+			}
+			return err
+		}
+		stdout = ptmx
+		stdin = ptmx
+	}
+	defer func() { _ = stdout.Close() }()
+	if stderr != nil {
+		defer func() { _ = stderr.Close() }()
+	}
+	if opts.onStart != nil {
+		opts.onStart(command.Process.Pid)
+	}
+
+	var term *terminal
+	if opts.RawTerminal && !opts.NoPty && opts.Terminal != nil {
+		term = newTerminal(opts.Terminal)
+	}
+	if term != nil {
+		if err := term.makeRaw(); err != nil {
+			log.Println("error setting terminal to raw mode:", err)
+		}
+		defer func() { _ = term.restore() }()
+	}
+
+	var handled []os.Signal
+	forwarded := make(map[os.Signal]bool)
+	if opts.ForwardSignals {
+		handled = append(handled, syscall.SIGWINCH)
+		if !opts.noJobControl {
+			handled = append(handled, syscall.SIGTSTP, syscall.SIGCONT)
+		}
+		forwardedSignals := opts.ForwardedSignals
+		if forwardedSignals == nil {
+			forwardedSignals = DefaultForwardedSignals
+		}
+		for _, sig := range forwardedSignals {
+			handled = append(handled, sig)
+			forwarded[sig] = true
+		}
+	}
+	if term != nil {
+		// Restore the terminal before dying from signals that aren't
+		// forwarded.
+		for _, sig := range []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM} {
+			if !forwarded[sig] {
+				handled = append(handled, sig)
+			}
+		}
+	}
+	if len(handled) > 0 {
+		resize := func() {
+			if opts.NoPty {
+				return
+			}
+			winsize := getPtyWinsize()
+			if winsize == nil {
+				return
+			}
+			if err := pty.Setsize(stdout, winsize); err != nil {
+				log.Println("error resizing pty:", err)
+			}
+			if stderr != nil {
+				_ = pty.Setsize(stderr, winsize)
+			}
+		}
+		sigs := make(chan os.Signal, len(handled))
+		signal.Notify(sigs, handled...)
+		defer signal.Stop(sigs)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				var sig os.Signal
+				select {
+				case <-done:
+					return
+				case sig = <-sigs:
+				}
+				switch {
+				case sig == syscall.SIGWINCH:
+					resize()
+
+				case sig == syscall.SIGTSTP:
+					// The command runs in a session of its own, so it never
+					// sees the terminal's SIGTSTP; stop it along with ourselves.
+					// SIGSTOP since SIGTSTP may be ignored, e.g. by shells, and
+					// since a caught SIGTSTP can't be reraised in Go.
+					if term != nil {
+						_ = term.restore()
+					}
+					_ = syscall.Kill(-command.Process.Pid, syscall.SIGSTOP)
+					_ = syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+
+				case sig == syscall.SIGCONT:
+					if term != nil {
+						if err := term.makeRaw(); err != nil {
+							log.Println("error setting terminal to raw mode:", err)
+						}
+					}
+					resize()
+					_ = syscall.Kill(-command.Process.Pid, syscall.SIGCONT)
+
+				case forwarded[sig]:
+					_ = syscall.Kill(-command.Process.Pid, sig.(syscall.Signal))
+
+				default:
+					// Die from the signal as if it weren't handled.
+					_ = term.restore()
+					signal.Reset(sig)
+					_ = syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+				}
+			}
+		}()
+		if opts.ForwardSignals {
+			sigs <- syscall.SIGWINCH
+		}
+	}
+
+	if opts.stdinRelay != nil {
+		opts.stdinRelay.attach(stdin)
+		defer opts.stdinRelay.detach()
+	} else if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(stdin, opts.Stdin)
+			if opts.NoPty {
+				// Signal EOF to the command.
+				_ = stdin.Close()
+			}
+		}()
+	}
+
+	waitErr := make(chan error, 1)
+	exited := make(chan struct{})
+	go func() {
+		waitErr <- command.Wait()
+		close(exited)
+	}()
+
+	// terminate sends SIGTERM to the command's process group, followed by
+	// SIGKILL if the command is still running KillGracePeriod later, which is
+	// announced with an event of the given kind. Only the first call has any
+	// effect.
+	killGracePeriod := opts.KillGracePeriod
+	if killGracePeriod <= 0 {
+		killGracePeriod = DefaultKillGracePeriod
+	}
+	var terminateOnce sync.Once
+	terminate := func(event string, data map[string]interface{}) {
+		terminateOnce.Do(func() {
+			_ = syscall.Kill(-command.Process.Pid, syscall.SIGTERM)
+			// In case the command is stopped.
+			_ = syscall.Kill(-command.Process.Pid, syscall.SIGCONT)
+			timer := time.NewTimer(killGracePeriod)
+			defer timer.Stop()
+			select {
+			case <-exited:
+				return
+			case <-timer.C:
+			}
+			text := fmt.Sprintf("still running %s after SIGTERM, sending SIGKILL", killGracePeriod)
+			data["signal"] = "SIGKILL"
+			_ = printer.PrintEvent(event, text, data)
+			_ = syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+		})
+	}
+
+	stopSampling := func() {}
+	if opts.SampleInterval > 0 {
+		samplePrinter := opts.SamplePrinter
+		if samplePrinter == nil {
+			samplePrinter = printer
+		}
+		stopSampling = startSampling(samplePrinter, command.Process.Pid, opts.SampleInterval)
+	}
+
+	if opts.cancel != nil {
+		go func() {
+			select {
+			case <-exited:
+			case <-opts.cancel:
+				terminate("stop", map[string]interface{}{})
+			}
+		}()
+	}
+
+	timedOut := make(chan struct{})
+	if opts.Timeout > 0 {
+		go func() {
+			timer := time.NewTimer(opts.Timeout)
+			defer timer.Stop()
+			select {
+			case <-exited:
+				return
+			case <-timer.C:
+			}
+			close(timedOut)
+			text := fmt.Sprintf("timed out after %s, sending SIGTERM", opts.Timeout)
+			_ = printer.PrintEvent("timeout", text, map[string]interface{}{"timeout": opts.Timeout.String(), "signal": "SIGTERM"})
+			terminate("timeout", map[string]interface{}{"timeout": opts.Timeout.String()})
+		}()
+	}
+
+	stalled := make(chan struct{})
+	if opts.StallTimeout > 0 {
+		go func() {
+			// The time of the last line when the command last stalled.
+			var stalledAfter time.Time
+			start := time.Now()
+			timer := time.NewTimer(opts.StallTimeout)
+			defer timer.Stop()
+			for {
+				select {
+				case <-exited:
+					return
+				case <-timer.C:
+				}
+				last := printer.lastLineTime()
+				if last.Before(start) {
+					last = start
+				}
+				idle := time.Since(last)
+				if idle < opts.StallTimeout {
+					timer.Reset(opts.StallTimeout - idle)
+					continue
+				}
+				timer.Reset(opts.StallTimeout)
+				if last.Equal(stalledAfter) {
+					// Already dealt with.
+					continue
+				}
+				stalledAfter = last
+				text := fmt.Sprintf("no output for %s", opts.StallTimeout)
+				data := map[string]interface{}{"stall_timeout": opts.StallTimeout.String()}
+				if opts.KillOnStall {
+					text += ", sending SIGTERM"
+					data["signal"] = "SIGTERM"
+				}
+				_ = printer.PrintEvent("stall", text, data)
+				if opts.OnStall != nil {
+					opts.OnStall(command.Process.Pid)
+				}
+				if opts.KillOnStall {
+					close(stalled)
+					terminate("stall", map[string]interface{}{"stall_timeout": opts.StallTimeout.String()})
+					return
+				}
+			}
+		}()
+	}
+
+	streamOptions := opts.StreamOptions
+	if opts.ExitGracePeriod > 0 {
+		stop := make(chan struct{})
+		readDone := make(chan struct{})
+		defer close(readDone)
+		streamOptions.Stop = stop
+		go func() {
+			select {
+			case <-exited:
+			case <-readDone:
+				return
+			}
+			timer := time.NewTimer(opts.ExitGracePeriod)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				close(stop)
+			case <-readDone:
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	stdoutStream := ""
+	var stderrStopped bool
+	if stderr != nil {
+		stdoutStream = StdoutStream
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stderrStopped = printCommandStream(stderr, printer, StderrStream, &streamOptions)
+		}()
+	}
+	stdoutStopped := printCommandStream(stdout, printer, stdoutStream, &streamOptions)
+	wg.Wait()
+
+	if stdoutStopped || stderrStopped {
+		unread := 0
+		for _, f := range []*os.File{stdout, stderr} {
+			if f != nil {
+				n, _ := unreadBytes(f)
+				unread += n
+			}
+		}
+		text := fmt.Sprintf("stopped reading output %s after the command exited, %d bytes left unread", opts.ExitGracePeriod, unread)
+		_ = printer.PrintEvent("unread", text, map[string]interface{}{"unread_bytes": unread})
+	}
+
+	err := <-waitErr
+	stopSampling()
+	if opts.ResourceUsage != NoResourceUsage && command.ProcessState != nil {
+		var usage ResourceUsage
+		var usageErr error
+		if opts.ResourceUsage == AllResourceUsage {
+			usage, usageErr = ChildrenResourceUsage()
+		} else if ru, ok := command.ProcessState.SysUsage().(*syscall.Rusage); ok {
+			usage = NewResourceUsage(ru)
+		} else {
+			usageErr = errors.New("resource usage unavailable")
+		}
+		if usageErr != nil {
+			log.Println("error getting resource usage:", usageErr)
+		} else {
+			_ = printer.PrintEvent("rusage", usage.String(), usage.Data())
+		}
+	}
+	select {
+	case <-timedOut:
+		return &TimeoutError{Timeout: opts.Timeout, Err: err}
+	case <-stalled:
+		return &StallError{StallTimeout: opts.StallTimeout, Err: err}
+	default:
+		return err
+	}
+}
+
+// startWithPipes starts command with its stdout and stderr attached to pipes
+// of their own, and its stdin to a pipe if withStdin, in a new process group.
+// It returns the read ends of the output pipes and the write end of the input
+// pipe, if any.
+func startWithPipes(command *exec.Cmd, withStdin bool) (stdout, stderr, stdin *os.File, err error) {
+	// The command's ends are closed once it holds its own copies; ours only
+	// on error.
+	var ours, theirs []*os.File
+	defer func() {
+		for _, f := range theirs {
+			_ = f.Close()
+		}
+		if err != nil {
+			for _, f := range ours {
+				_ = f.Close()
+			}
+		}
+	}()
+
+	var stdoutW, stderrW, stdinR *os.File
+	if stdout, stdoutW, err = os.Pipe(); err != nil {
+		return
+	}
+	ours, theirs = append(ours, stdout), append(theirs, stdoutW)
+	if stderr, stderrW, err = os.Pipe(); err != nil {
+		return
+	}
+	ours, theirs = append(ours, stderr), append(theirs, stderrW)
+	command.Stdout = stdoutW
+	command.Stderr = stderrW
+	if withStdin {
+		if stdinR, stdin, err = os.Pipe(); err != nil {
+			return
+		}
+		ours, theirs = append(ours, stdin), append(theirs, stdinR)
+		command.Stdin = stdinR
+	}
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = command.Start()
+	return
+}
+
+// printCommandStream is PrintStream for a pty master or a pipe, logging read
+// errors other than EIO, which is what reading a pty master returns on Linux
+// once all of the pty's slave fds are closed. It returns whether reading was
+// stopped by opts.Stop.
+func printCommandStream(f *os.File, printer *Printer, stream string, opts *StreamOptions) bool {
+	err := PrintStream(f, printer, stream, opts)
+	if err == ErrStopped {
+		return true
+	}
+	if err != nil && !errors.Is(err, syscall.EIO) {
+		log.Println("error reading command output:", err)
+	}
+	return false
+}
+
+// unreadBytes returns the number of bytes available for reading from f.
+func unreadBytes(f *os.File) (int, error) {
+	var n int32
+	if err := ioctl(f.Fd(), ioctlInputQueue, uintptr(unsafe.Pointer(&n))); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,6 @@
+// Package ets implements the timestamping machinery behind the ets command:
+// a Timestamper producing absolute, elapsed or incremental timestamps, a
+// bufio.SplitFunc recognizing CR, LF and CRLF line endings, a TimestampWriter
+// for plugging timestamping into any io.Writer, and helpers to timestamp an
+// arbitrary stream or a command running in a pty.
+package ets
@@ -0,0 +1,65 @@
+package ets
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ExitStatus describes how a command exited.
+type ExitStatus struct {
+	// Code is the exit code, or -1 if the command was terminated by a
+	// signal.
+	Code int
+	// Signal is the signal that terminated the command, or 0.
+	Signal syscall.Signal
+	// CoreDump is set if the command dumped core.
+	CoreDump bool
+}
+
+// NewExitStatus returns the ExitStatus of an exited process.
+func NewExitStatus(state *os.ProcessState) ExitStatus {
+	status := ExitStatus{Code: state.ExitCode()}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		status.Signal = ws.Signal()
+		status.CoreDump = ws.CoreDump()
+	}
+	return status
+}
+
+// Signaled reports whether the command was terminated by a signal.
+func (s ExitStatus) Signaled() bool {
+	return s.Signal != 0
+}
+
+// ShellCode returns the exit code a shell would report: the exit code, or
+// 128+N if the command was terminated by signal N.
+func (s ExitStatus) ShellCode() int {
+	if s.Signaled() {
+		return 128 + int(s.Signal)
+	}
+	return s.Code
+}
+
+// String describes the exit status, e.g. "exited with status 1" or
+// "terminated by SIGSEGV (core dumped)".
+func (s ExitStatus) String() string {
+	if !s.Signaled() {
+		return fmt.Sprintf("exited with status %d", s.Code)
+	}
+	desc := "terminated by " + SignalName(s.Signal)
+	if s.CoreDump {
+		desc += " (core dumped)"
+	}
+	return desc
+}
+
+// Data returns the exit status as event data for Printer.PrintEvent.
+func (s ExitStatus) Data() map[string]interface{} {
+	data := map[string]interface{}{"exit_code": s.ShellCode()}
+	if s.Signaled() {
+		data["signal"] = SignalName(s.Signal)
+		data["core_dumped"] = s.CoreDump
+	}
+	return data
+}
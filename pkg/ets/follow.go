@@ -0,0 +1,183 @@
+package ets
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is the default FollowOptions.PollInterval.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// FollowOptions controls Follow.
+type FollowOptions struct {
+	// StreamOptions controls how lines are read from each file. Closing
+	// Stop ends following.
+	StreamOptions
+	// PollInterval is how often files are checked for new data, truncation
+	// and replacement at their end. Zero means DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+// Follow prints lines appended to the files at paths as they arrive, like
+// tail -F, until opts.Stop is closed. Content present when Follow starts is
+// skipped. A file that is replaced, e.g. rotated by renaming, is followed by
+// path, from the start of the new file once the old one has been read to the
+// end; a file that is truncated is read again from the start; a file that
+// doesn't exist (yet) is waited for. These are announced with "follow"
+// events. With more than one file, lines are labeled with their file's path
+// (see Printer.Labeled).
+//
+// The returned error is the first error reading a file, if any.
+func Follow(paths []string, printer *Printer, opts *FollowOptions) error {
+	if opts == nil {
+		opts = &FollowOptions{}
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	printers := make([]*Printer, len(paths))
+	for i, path := range paths {
+		printers[i] = printer
+		if len(paths) > 1 {
+			printers[i] = printer.Labeled(path)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		r := &followReader{path: path, printer: printers[i], interval: interval, stop: opts.Stop}
+		r.open(false)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := PrintStream(r, printers[i], "", &opts.StreamOptions); err != ErrStopped {
+				errs[i] = err
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followReader reads a file by path like tail -F: at the end of the file, it
+// waits for more data, switching to a new file at the same path when the file
+// is replaced, and rereading the file from the start when it is truncated.
+// Reading ends with io.EOF once stop is closed, which closes the file.
+type followReader struct {
+	path     string
+	printer  *Printer
+	interval time.Duration
+	stop     <-chan struct{}
+	file     *os.File
+	// Where the next read from file starts.
+	offset int64
+	// Whether the file was missing when last opened, which was announced.
+	missing bool
+	// Whether the file was found replaced, and read once more since, in
+	// case it was written to in the meantime.
+	replaced bool
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		if r.file != nil {
+			n, err := r.file.Read(p)
+			r.offset += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				r.close()
+				return 0, err
+			}
+			if r.check() {
+				continue
+			}
+		} else if r.open(true) {
+			continue
+		}
+		timer := time.NewTimer(r.interval)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			r.close()
+			return 0, io.EOF
+		case <-timer.C:
+		}
+	}
+}
+
+// open opens the file, from the start if fromStart, or from the end otherwise,
+// and reports whether it succeeded.
+func (r *followReader) open(fromStart bool) bool {
+	f, err := os.Open(r.path)
+	if err != nil {
+		if !r.missing {
+			r.missing = true
+			r.notify(fmt.Sprintf("%s, waiting for the file to appear", err), "missing")
+		}
+		return false
+	}
+	r.file = f
+	r.offset = 0
+	if !fromStart {
+		if r.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			r.offset = 0
+		}
+	}
+	if r.missing {
+		r.missing = false
+		r.notify(fmt.Sprintf("%s has appeared, following it", r.path), "appeared")
+	}
+	return true
+}
+
+// check checks the file at the end for replacement or truncation, in which
+// case it reopens it or rewinds it respectively, and returns true.
+func (r *followReader) check() bool {
+	current, err := r.file.Stat()
+	if err != nil {
+		return false
+	}
+	if info, err := os.Stat(r.path); err == nil && !os.SameFile(info, current) {
+		if !r.replaced {
+			r.replaced = true
+			return true
+		}
+		r.replaced = false
+		r.close()
+		r.notify(fmt.Sprintf("%s has been replaced, following the new file", r.path), "replaced")
+		return r.open(true)
+	}
+	if current.Size() < r.offset {
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+		r.offset = 0
+		r.notify(fmt.Sprintf("%s has been truncated, reading it from the start", r.path), "truncated")
+		return true
+	}
+	return false
+}
+
+func (r *followReader) close() {
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+}
+
+// notify prints a "follow" event about a change to the file.
+func (r *followReader) notify(text string, change string) {
+	_ = r.printer.PrintEvent("follow", text, map[string]interface{}{"file": r.path, "change": change})
+}
@@ -0,0 +1,145 @@
+package ets
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(log, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	appendLine := func(line string) {
+		f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Long enough for several polls.
+	pause := func() { time.Sleep(100 * time.Millisecond) }
+
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf lockedBuffer
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		opts := &FollowOptions{PollInterval: 10 * time.Millisecond}
+		opts.Stop = stop
+		done <- Follow([]string{log}, NewPrinter(&buf, timestamper, PlainFormat), opts)
+	}()
+	pause()
+	appendLine("new\n")
+	pause()
+	// Rotation: the old file gets a last line after the rename.
+	if err := os.Rename(log, log+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLine("rotated\n")
+	pause()
+	if err := os.Truncate(log, 0); err != nil {
+		t.Fatal(err)
+	}
+	pause()
+	appendLine("truncated\n")
+	pause()
+	if err := os.Remove(log); err != nil {
+		t.Fatal(err)
+	}
+	pause()
+	appendLine("recreated\n")
+	pause()
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := "[ts] new\n" +
+		"[ts] " + log + " has been replaced, following the new file\n" +
+		"[ts] rotated\n" +
+		"[ts] " + log + " has been truncated, reading it from the start\n" +
+		"[ts] truncated\n" +
+		"[ts] " + log + " has been replaced, following the new file\n" +
+		"[ts] recreated\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestFollowMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.log")
+	b := filepath.Join(dir, "b.log")
+	if err := ioutil.WriteFile(a, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf lockedBuffer
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		opts := &FollowOptions{PollInterval: 10 * time.Millisecond}
+		opts.Stop = stop
+		done <- Follow([]string{a, b}, NewPrinter(&buf, timestamper, LogfmtFormat), opts)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := ioutil.WriteFile(b, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+	for _, expected := range []string{
+		"label=" + b + " event=follow change=missing file=" + b + " msg=\"open " + b + ": no such file or directory, waiting for the file to appear\"\n",
+		"label=" + b + " event=follow change=appeared file=" + b + " msg=\"" + b + " has appeared, following it\"\n",
+		"label=" + b + " msg=hello\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %#v in output %#v", expected, output)
+		}
+	}
+}
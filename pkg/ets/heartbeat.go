@@ -0,0 +1,76 @@
+package ets
+
+import (
+	"fmt"
+	"time"
+)
+
+// StartHeartbeats prints a heartbeat, a line of the form "… still running
+// (elapsed 00:12:00)", whenever no line has been printed for interval, as
+// proof of liveness during long silences. Heartbeats are "heartbeat" events,
+// but unlike other lines, they don't affect the timestamps of other lines in
+// IncrementalTimeMode, and they are skipped while a partial line is open
+// rather than interrupting it. See also HeartbeatsEverywhere.
+//
+// The elapsed time is measured from the call. StartHeartbeats returns a
+// function stopping the heartbeats.
+func (p *Printer) StartHeartbeats(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		start := time.Now()
+		last := start
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			if lastLine := p.lastLineTime(); lastLine.After(last) {
+				last = lastLine
+			}
+			now := time.Now()
+			if idle := now.Sub(last); idle < interval {
+				timer.Reset(interval - idle)
+				continue
+			}
+			elapsed := now.Sub(start)
+			text := fmt.Sprintf("… still running (elapsed %s)", formatElapsed(elapsed))
+			_ = p.printHeartbeat(now, text, map[string]interface{}{"elapsed": elapsed.Round(time.Second).String()})
+			last = now
+			timer.Reset(interval)
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// printHeartbeat writes a heartbeat line, unless a partial line is open.
+func (p *Printer) printHeartbeat(at time.Time, text string, data map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasPartial {
+		return nil
+	}
+	line := &Line{Event: "heartbeat", Text: text, Terminator: "\n", Data: data}
+	line.Timestamp = p.timestamper.peek(at, nil)
+	if !p.HeartbeatsEverywhere {
+		if p.format != PlainFormat {
+			return nil
+		}
+		_, err := p.w.Write(p.encode(line, p.Color))
+		return err
+	}
+	return p.emit(line)
+}
+
+// formatElapsed formats d as HH:MM:SS, with hours not wrapping around.
+func formatElapsed(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,62 @@
+package ets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrinterHeartbeats(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf, teeBuf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, PlainFormat)
+	printer.Tee(&teeBuf, TeeOptions{})
+	stop := printer.StartHeartbeats(100 * time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	_ = printer.PrintLine("", []byte("line\n"))
+	time.Sleep(150 * time.Millisecond)
+	// Heartbeats don't interrupt partial lines.
+	_ = printer.PrintPartial("", []byte("partial"))
+	time.Sleep(150 * time.Millisecond)
+	stop()
+	expected := "[ts] … still running (elapsed 00:00:00)\n[ts] line\n[ts] … still running (elapsed 00:00:00)\n[ts] partial"
+	if buf.String() != expected {
+		t.Errorf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+	if strings.Contains(teeBuf.String(), "still running") {
+		t.Errorf("unexpected heartbeat in tee: %#v", teeBuf.String())
+	}
+
+	buf.Reset()
+	printer = NewPrinter(&buf, timestamper, JSONLinesFormat)
+	stop = printer.StartHeartbeats(50 * time.Millisecond)
+	time.Sleep(80 * time.Millisecond)
+	stop()
+	if buf.Len() != 0 {
+		t.Errorf("unexpected heartbeat in structured output: %#v", buf.String())
+	}
+	printer.HeartbeatsEverywhere = true
+	stop = printer.StartHeartbeats(50 * time.Millisecond)
+	time.Sleep(80 * time.Millisecond)
+	stop()
+	if !strings.Contains(buf.String(), `"event":"heartbeat"`) {
+		t.Errorf("expected heartbeat in structured output, got %#v", buf.String())
+	}
+}
+
+func TestFormatElapsed(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		0:                                      "00:00:00",
+		12*time.Minute + 1500*time.Millisecond: "00:12:01",
+		26*time.Hour + 3*time.Second:           "26:00:03",
+	} {
+		if s := formatElapsed(d); s != expected {
+			t.Errorf("formatElapsed(%s): expected %s, got %s", d, expected, s)
+		}
+	}
+}
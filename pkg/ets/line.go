@@ -0,0 +1,46 @@
+package ets
+
+// Line is a single timestamped line of output.
+type Line struct {
+	Timestamp
+	// Text is the content of the line, without line ending.
+	Text string
+	// Terminator is the line ending: "\n" (LF, or CRLF collapsed to LF),
+	// "\r", or "" for a partial or wrapped line, or a final line without
+	// line ending.
+	Terminator string
+	// Partial is set if the line's ending hasn't arrived yet; the next line
+	// from the same stream continues it.
+	Partial bool
+	// Continued is set if the line continues a partial line.
+	Continued bool
+	// Wrapped is set if the line is the first part of a line too long to be
+	// printed in one piece; the next line from the same stream continues it.
+	Wrapped bool
+	// Event, if set, names the kind of line generated by ets itself rather
+	// than read from a stream (see Printer.PrintEvent).
+	Event string
+	// Data is additional data about an event, for structured output formats.
+	Data map[string]interface{}
+	// Stream is the stream the line came from, StdoutStream or
+	// StderrStream, or "" if unknown (e.g. both streams share a pty).
+	Stream string
+	// Label is the label of the Printer the line was printed with, if
+	// obtained with Printer.Labeled.
+	Label string
+	// Restart is the number of times the command had been restarted when
+	// the line was printed (see Supervise).
+	Restart int
+}
+
+// Stream names.
+const (
+	StdoutStream = "stdout"
+	StderrStream = "stderr"
+)
+
+// splitLine splits a token returned by ScanLines into text and terminator.
+func splitLine(token []byte) (text string, terminator string) {
+	ending := lineEnding(token)
+	return string(token[:len(token)-len(ending)]), string(ending)
+}
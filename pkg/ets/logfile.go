@@ -0,0 +1,133 @@
+package ets
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// RotatingFileOptions controls when a RotatingFile is rotated, and how many
+// rotated files are retained.
+type RotatingFileOptions struct {
+	// MaxSize is the size in bytes beyond which the file is rotated. 0
+	// means no size limit.
+	MaxSize int64
+	// MaxAge is the interval after which the file is rotated. 0 means no
+	// time-based rotation.
+	MaxAge time.Duration
+	// MaxFiles is the number of rotated files retained. 0 means all rotated
+	// files are retained.
+	MaxFiles int
+}
+
+// RotatingFile is an io.WriteCloser appending to a file, rotating it
+// according to RotatingFileOptions. The current file is always at path;
+// rotated files are named path.1 (the most recent), path.2, and so on.
+//
+// RotatingFile is not safe for concurrent use.
+type RotatingFile struct {
+	path     string
+	opts     RotatingFileOptions
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// OpenRotatingFile opens path for appending, creating it if necessary.
+func OpenRotatingFile(path string, opts RotatingFileOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would take it over
+// MaxSize, or if it is older than MaxAge. Writes are never split across
+// files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+int64(n) > f.opts.MaxSize {
+		return true
+	}
+	if f.opts.MaxAge > 0 && time.Since(f.openedAt) >= f.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	// Find the oldest rotated file, then shift everything down by one.
+	last := 0
+	for {
+		if _, err := os.Lstat(f.rotatedPath(last + 1)); err != nil {
+			break
+		}
+		last++
+	}
+	for i := last; i >= 1; i-- {
+		if f.opts.MaxFiles > 0 && i >= f.opts.MaxFiles {
+			if err := os.Remove(f.rotatedPath(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(f.rotatedPath(i), f.rotatedPath(i+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.rotatedPath(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
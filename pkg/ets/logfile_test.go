@@ -0,0 +1,40 @@
+package ets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	f, err := OpenRotatingFile(path, RotatingFileOptions{MaxSize: 10, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n", "oversized line5\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		path:        "oversized line5\n",
+		path + ".1": "line4\n",
+		path + ".2": "line3\n",
+	}
+	for p, content := range expected {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("wrong content of %s: expected %#v, got %#v", p, content, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 to have been removed", path)
+	}
+}
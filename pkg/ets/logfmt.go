@@ -0,0 +1,56 @@
+package ets
+
+import (
+	"bytes"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// appendLogfmtPair writes key=value to buf, quoting and escaping value as
+// necessary. Escapes follow JSON string syntax, which is what common logfmt
+// parsers understand.
+func appendLogfmtPair(buf *bytes.Buffer, key string, value string) {
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if !logfmtNeedsQuoting(value) {
+		buf.WriteString(value)
+		return
+	}
+	buf.WriteByte('"')
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		i += size
+		switch r {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				// utf8.RuneError for invalid UTF-8 is written as U+FFFD.
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func logfmtNeedsQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package ets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppendLogfmtPair(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"plain", `k=plain`},
+		{"", `k=""`},
+		{"two words", `k="two words"`},
+		{"a=b", `k="a=b"`},
+		{`say "hi"`, `k="say \"hi\""`},
+		{`back\slash`, `k=back\slash`},
+		{"back\\ slash", `k="back\\ slash"`},
+		{"tab\there", `k="tab\there"`},
+		{"\x1b[32mgreen\x1b[0m", `k="\u001b[32mgreen\u001b[0m"`},
+		{"时间", `k=时间`},
+		{"bad\xffbyte", "k=\"bad�byte\""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		appendLogfmtPair(&buf, "k", test.value)
+		if buf.String() != test.expected {
+			t.Errorf("wrong encoding of %#v: expected %s, got %s", test.value, test.expected, buf.String())
+		}
+	}
+}
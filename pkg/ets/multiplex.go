@@ -0,0 +1,159 @@
+package ets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ExitPolicy determines what Multiplex does when one of its commands exits.
+type ExitPolicy int
+
+const (
+	// KeepRunning lets the other commands run until they exit on their own.
+	KeepRunning ExitPolicy = iota
+	// StopAll terminates the other commands as soon as one exits.
+	StopAll
+	// StopOnFailure terminates the other commands as soon as one fails,
+	// i.e. exits with a nonzero status, is terminated by a signal, or can't
+	// be started.
+	StopOnFailure
+)
+
+// LabeledCommand is a command run by Multiplex.
+type LabeledCommand struct {
+	// Label tells the command's lines apart from the others' (see
+	// Printer.Labeled).
+	Label string
+	Args  []string
+}
+
+// Multiplex runs commands concurrently, each as with RunCommand, and prints
+// their output with printer as it arrives, each command's lines labeled with
+// its label. When a command exits, its exit status is printed with an "exit"
+// event (or its error with an "error" event), and the other commands are
+// terminated as policy says, which is announced with a "stop" event.
+//
+// opts applies to every command, except that commands get no input and
+// RawTerminal has no effect. With ForwardSignals, forwarded signals are
+// relayed to every command, and all commands are stopped and continued along
+// with the current process. A separate SamplePrinter is labeled too.
+//
+// The returned errs are those of RunCommand for each command, in order.
+// first is the index of the command deciding the outcome: the first to fail,
+// or with StopAll, the first to exit; or -1 if all commands succeeded.
+func Multiplex(commands []LabeledCommand, printer *Printer, policy ExitPolicy, opts *CommandOptions) (errs []error, first int) {
+	if opts == nil {
+		opts = &CommandOptions{}
+	}
+	printers := make([]*Printer, len(commands))
+	for i, c := range commands {
+		printers[i] = printer.Labeled(c.Label)
+	}
+
+	stop := make(chan struct{})
+	var pidsMu sync.Mutex
+	var pids []int
+	if opts.ForwardSignals {
+		// Job control is done here rather than by each command, so that the
+		// current process stops once, after all commands.
+		sigs := make(chan os.Signal, 2)
+		signal.Notify(sigs, syscall.SIGTSTP, syscall.SIGCONT)
+		defer signal.Stop(sigs)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case sig := <-sigs:
+					pidsMu.Lock()
+					for _, pid := range pids {
+						if sig == syscall.SIGTSTP {
+							_ = syscall.Kill(-pid, syscall.SIGSTOP)
+						} else {
+							_ = syscall.Kill(-pid, syscall.SIGCONT)
+						}
+					}
+					pidsMu.Unlock()
+					if sig == syscall.SIGTSTP {
+						_ = syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+					}
+				}
+			}
+		}()
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result)
+	for i, c := range commands {
+		commandOpts := *opts
+		commandOpts.Stdin = nil
+		commandOpts.RawTerminal = false
+		commandOpts.cancel = stop
+		commandOpts.noJobControl = true
+		if opts.SamplePrinter != nil {
+			commandOpts.SamplePrinter = opts.SamplePrinter.Labeled(c.Label)
+		}
+		go func(i int, args []string) {
+			var pid int
+			commandOpts.onStart = func(p int) {
+				pid = p
+				pidsMu.Lock()
+				pids = append(pids, pid)
+				pidsMu.Unlock()
+			}
+			err := RunCommand(args, printers[i], &commandOpts)
+			// The pid may be reused once the command has exited.
+			pidsMu.Lock()
+			for j, p := range pids {
+				if p == pid {
+					pids = append(pids[:j], pids[j+1:]...)
+					break
+				}
+			}
+			pidsMu.Unlock()
+			results <- result{i, err}
+		}(i, c.Args)
+	}
+
+	errs = make([]error, len(commands))
+	first = -1
+	stopping := false
+	for remaining := len(commands); remaining > 0; remaining-- {
+		r := <-results
+		errs[r.index] = r.err
+		var exitErr *exec.ExitError
+		var description string
+		if r.err == nil || errors.As(r.err, &exitErr) {
+			status := ExitStatus{}
+			if exitErr != nil {
+				status = NewExitStatus(exitErr.ProcessState)
+			}
+			description = status.String()
+			_ = printers[r.index].PrintEvent("exit", description, status.Data())
+		} else {
+			description = "failed: " + r.err.Error()
+			_ = printers[r.index].PrintEvent("error", description, nil)
+		}
+		failed := r.err != nil
+		if first < 0 && (failed || policy == StopAll) {
+			first = r.index
+		}
+		if !stopping && remaining > 1 && (policy == StopAll || policy == StopOnFailure && failed) {
+			stopping = true
+			text := fmt.Sprintf("stopping the other commands since %s %s", commands[r.index].Label, description)
+			_ = printer.PrintEvent("stop", text, map[string]interface{}{"label": commands[r.index].Label})
+			close(stop)
+		}
+	}
+	return errs, first
+}
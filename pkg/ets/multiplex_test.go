@@ -0,0 +1,93 @@
+package ets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrinterLabeled(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, PlainFormat)
+	api := printer.Labeled("api")
+	worker := printer.Labeled("worker")
+	_ = api.PrintPartial("", []byte("a"))
+	_ = worker.PrintLine("", []byte("w\n"))
+	_ = api.PrintLine("", []byte("b\n"))
+	_ = printer.PrintEvent("stop", "stopping", nil)
+	expected := "[ts] api    | a\n[ts] worker | w\n[ts] api    | b\n[ts] stopping\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+	if api.PrefixWidth() != printer.PrefixWidth()+len("worker | ") {
+		t.Errorf("wrong prefix width %d", api.PrefixWidth())
+	}
+	if printer.Stats().Lines != 3 {
+		t.Errorf("labeled lines not counted: %+v", printer.Stats())
+	}
+}
+
+func TestPrinterLabeledDirective(t *testing.T) {
+	timestamper, err := NewTimestamper("[%{label}]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, LogfmtFormat)
+	_ = printer.Labeled("api").PrintLine("", []byte("hi\n"))
+	if !strings.Contains(buf.String(), " label=api msg=hi\n") {
+		t.Fatalf("wrong output %#v", buf.String())
+	}
+}
+
+func TestMultiplex(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ExitPolicy
+		// Index of the first command deciding the outcome, and the output
+		// expected after b exits, if it does.
+		first    int
+		expected string
+	}{
+		{"keep", KeepRunning, 1, "[ts] b | exited with status 3\n[ts] a | a\n[ts] a | exited with status 0\n"},
+		{"stop", StopAll, 2, ""},
+		{"failure", StopOnFailure, 1, "[ts] b | exited with status 3\n[ts] stopping the other commands since b exited with status 3\n[ts] a | terminated by SIGTERM\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			printer := NewPrinter(&buf, timestamper, PlainFormat)
+			commands := []LabeledCommand{
+				{"a", []string{"sh", "-c", "sleep 1; echo a"}},
+				{"b", []string{"sh", "-c", "sleep 0.3; echo b; exit 3"}},
+				{"c", []string{"sh", "-c", "echo c"}},
+			}
+			errs, first := Multiplex(commands, printer, test.policy, nil)
+			if first != test.first {
+				t.Errorf("expected first %d, got %d", test.first, first)
+			}
+			if errs[2] != nil {
+				t.Errorf("unexpected error of c: %v", errs[2])
+			}
+			output := buf.String()
+			if !strings.HasPrefix(output, "[ts] c | c\n[ts] c | exited with status 0\n") {
+				t.Errorf("wrong output %#v", output)
+			}
+			if test.expected != "" && !strings.HasSuffix(output, test.expected) {
+				t.Errorf("wrong output: expected suffix %#v, got %#v", test.expected, output)
+			}
+			if test.policy == StopAll && !strings.Contains(output, "stopping the other commands since c exited with status 0\n") {
+				t.Errorf("wrong output %#v", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package ets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// OutputFormat determines how a Printer renders timestamped lines.
+type OutputFormat int
+
+const (
+	// PlainFormat prefixes each line with the formatted timestamp and a
+	// space, preserving the line ending.
+	PlainFormat OutputFormat = iota
+	// JSONLinesFormat renders each line as a JSON object on its own line.
+	JSONLinesFormat
+	// LogfmtFormat renders each line as a logfmt record with keys ts (RFC
+	// 3339 wall time), elapsed, stream (if known) and msg.
+	LogfmtFormat
+)
+
+// Printer timestamps lines and writes them to an io.Writer in an
+// OutputFormat. Printer is safe for concurrent use, but its exported fields
+// must be set before first use.
+type Printer struct {
+	// Color shows timestamps of PlainFormat lines in color: green, or red
+	// for lines from stderr.
+	Color bool
+	// WrapMarker is appended to wrapped lines in PlainFormat.
+	WrapMarker string
+	// HeartbeatsEverywhere writes heartbeats (see StartHeartbeats) to tees
+	// and in structured output formats too. By default, they are meant for
+	// human eyes only, and only written in PlainFormat to the primary
+	// destination.
+	HeartbeatsEverywhere bool
+
+	w           io.Writer
+	timestamper *Timestamper
+	format      OutputFormat
+	tees        []tee
+	mu          sync.Mutex
+	// Whether the last line printed is partial, and its stream and label.
+	hasPartial    bool
+	partialStream string
+	partialLabel  string
+	// When the last line other than an event was printed.
+	lastLine time.Time
+	stats    Stats
+	// Timestamp of the last line other than an event.
+	lastLineStamp time.Time
+	// Labels of the Printers returned by Labeled, mapped to their colors,
+	// and the width of the widest.
+	labels     map[string]string
+	labelWidth int
+	// For a Printer returned by Labeled, the Printer doing the printing and
+	// the label of the lines.
+	parent *Printer
+	label  string
+	// Number of times the command has been restarted (see Supervise).
+	restarts int
+}
+
+// labelColors are the colors cycled through for labels, as with
+// docker-compose.
+var labelColors = []string{"36", "33", "35", "34", "96", "93", "95", "94"}
+
+// TeeOptions controls an additional copy of a Printer's output.
+type TeeOptions struct {
+	// StripANSI removes ANSI escape sequences (e.g. colors) from lines and
+	// timestamps in the copy.
+	StripANSI bool
+}
+
+type tee struct {
+	w    io.Writer
+	opts TeeOptions
+}
+
+// NewPrinter returns a Printer writing to w.
+func NewPrinter(w io.Writer, timestamper *Timestamper, format OutputFormat) *Printer {
+	return &Printer{w: w, timestamper: timestamper, format: format}
+}
+
+// Tee adds w as an additional destination of the Printer's output, in the
+// same output format. It must be called before first use.
+func (p *Printer) Tee(w io.Writer, opts TeeOptions) {
+	p.tees = append(p.tees, tee{w: w, opts: opts})
+}
+
+// Labeled returns a Printer printing lines through p, labeled with label,
+// e.g. to tell apart the output of several commands printed together. In
+// PlainFormat, the label follows the timestamp, padded to the width of the
+// widest label and, with Color, in a color of its own; in structured output
+// formats, it is a field of its own. The label is also available to the
+// timestamp format as %{label}.
+//
+// All labels should be obtained before first use. Lines printed with the
+// returned Printer count towards p's Stats; the returned Printer itself only
+// supports printing, and its exported fields and Tee are ignored.
+func (p *Printer) Labeled(label string) *Printer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.labels == nil {
+		p.labels = make(map[string]string)
+	}
+	if _, ok := p.labels[label]; !ok {
+		p.labels[label] = labelColors[len(p.labels)%len(labelColors)]
+	}
+	if width := runewidth.StringWidth(label); width > p.labelWidth {
+		p.labelWidth = width
+	}
+	return &Printer{w: p.w, timestamper: p.timestamper, format: p.format, parent: p, label: label}
+}
+
+// Timestamper returns the Timestamper used by the Printer.
+func (p *Printer) Timestamper() *Timestamper {
+	return p.timestamper
+}
+
+// PrintLine timestamps and writes a line as returned by ScanLines, i.e.
+// including its line ending, if any. stream is the name of the stream the
+// line came from, or "" if unknown.
+func (p *Printer) PrintLine(stream string, token []byte) error {
+	text, terminator := splitLine(token)
+	return p.print(time.Now(), &Line{Stream: stream, Text: text, Terminator: terminator})
+}
+
+// PrintPartial timestamps and writes the beginning of a line whose ending
+// hasn't arrived yet. The rest of the line, passed to PrintPartial or
+// PrintLine with the same stream later, continues the line without a new
+// timestamp in PlainFormat, unless a line from another stream is printed in
+// between.
+func (p *Printer) PrintPartial(stream string, text []byte) error {
+	return p.print(time.Now(), &Line{Stream: stream, Text: string(text), Partial: true})
+}
+
+// PrintWrapped timestamps and writes the first part of a line too long to be
+// printed in one piece. The rest of the line is printed as a new line.
+func (p *Printer) PrintWrapped(stream string, text []byte) error {
+	return p.print(time.Now(), &Line{Stream: stream, Text: string(text), Wrapped: true})
+}
+
+// PrintEvent timestamps and writes a line generated by ets itself rather than
+// read from a stream, e.g. a notice that the command was terminated by a
+// signal. event names the kind of line; data, which may be nil, is included
+// in structured output formats.
+func (p *Printer) PrintEvent(event string, text string, data map[string]interface{}) error {
+	return p.print(time.Now(), &Line{Event: event, Text: text, Terminator: "\n", Data: data})
+}
+
+// print stamps line with the given time and writes it. All fields of line but
+// Timestamp and Continued should be filled in.
+func (p *Printer) print(at time.Time, line *Line) error {
+	if p.parent != nil {
+		line.Label = p.label
+		if line.Event == "" {
+			p.mu.Lock()
+			p.lastLine = time.Now()
+			p.mu.Unlock()
+		}
+		return p.parent.print(at, line)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasPartial {
+		if line.Event == "" && p.partialStream == line.Stream && p.partialLabel == line.Label {
+			line.Continued = true
+		} else if err := p.interruptPartial(); err != nil {
+			return err
+		}
+	}
+	line.Restart = p.restarts
+	line.Timestamp = p.timestamper.Stamp(at, map[string]string{
+		"stream":  line.Stream,
+		"label":   line.Label,
+		"restart": strconv.Itoa(p.restarts),
+	})
+	if line.Event == "" {
+		p.lastLine = time.Now()
+		p.recordStats(line)
+	}
+	p.hasPartial = line.Partial
+	p.partialStream = line.Stream
+	p.partialLabel = line.Label
+	return p.emit(line)
+}
+
+// setRestarts sets the number of times the command has been restarted.
+func (p *Printer) setRestarts(restarts int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.restarts = restarts
+}
+
+// lastLineTime returns when the last line other than an event was printed, or
+// the zero time if none was.
+func (p *Printer) lastLineTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastLine
+}
+
+// interruptPartial ends the current partial line, so that something else can
+// be printed on a line of its own. The rest of the partial line will be
+// printed as a new line.
+func (p *Printer) interruptPartial() error {
+	p.hasPartial = false
+	if p.format != PlainFormat {
+		return nil
+	}
+	_, err := p.w.Write([]byte{'\n'})
+	for _, t := range p.tees {
+		if _, teeErr := t.w.Write([]byte{'\n'}); err == nil {
+			err = teeErr
+		}
+	}
+	return err
+}
+
+// emit writes line to all destinations, returning the first error.
+func (p *Printer) emit(line *Line) error {
+	_, err := p.w.Write(p.encode(line, p.Color))
+	if teeErr := p.emitToTees(line); err == nil {
+		err = teeErr
+	}
+	return err
+}
+
+// emitToTees writes line to the destinations added with Tee, returning the
+// first error.
+func (p *Printer) emitToTees(line *Line) error {
+	var err error
+	for _, t := range p.tees {
+		teeLine := line
+		if t.opts.StripANSI {
+			stripped := *line
+			stripped.Formatted = ansiEscapes.ReplaceAllString(line.Formatted, "")
+			stripped.Text = ansiEscapes.ReplaceAllString(line.Text, "")
+			teeLine = &stripped
+		}
+		if _, teeErr := t.w.Write(p.encode(teeLine, p.Color && !t.opts.StripANSI)); err == nil {
+			err = teeErr
+		}
+	}
+	return err
+}
+
+// PrefixWidth returns the number of terminal columns taken up by the
+// timestamp prefix in the Printer's output format, or 0 if the format isn't
+// meant for a terminal.
+func (p *Printer) PrefixWidth() int {
+	if p.format != PlainFormat {
+		return 0
+	}
+	if p.parent != nil {
+		return p.parent.prefixWidth(p.label)
+	}
+	return p.prefixWidth("")
+}
+
+// prefixWidth returns PrefixWidth for lines with the given label.
+func (p *Printer) prefixWidth(label string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Stream names are all of the same width.
+	fields := map[string]string{"stream": StdoutStream, "label": label, "restart": strconv.Itoa(p.restarts)}
+	plainTimestampString := ansiEscapes.ReplaceAllString(p.timestamper.format(time.Now(), fields), "")
+	// Timestamp width along with one space character.
+	width := runewidth.StringWidth(plainTimestampString) + 1
+	if label != "" {
+		// Padded label followed by " | ".
+		width += p.labelWidth + 3
+	}
+	return width
+}
+
+func (p *Printer) encode(line *Line, color bool) []byte {
+	var buf bytes.Buffer
+	switch p.format {
+	case PlainFormat:
+		if line.Continued {
+			p.appendTextPlain(&buf, line)
+			break
+		}
+		if color {
+			if line.Stream == StderrStream {
+				buf.WriteString("\x1b[31m")
+			} else {
+				buf.WriteString("\x1b[32m")
+			}
+			buf.WriteString(line.Formatted)
+			buf.WriteString("\x1b[0m")
+		} else {
+			buf.WriteString(line.Formatted)
+		}
+		buf.WriteByte(' ')
+		if line.Label != "" {
+			p.appendLabelPlain(&buf, line.Label, color)
+		}
+		p.appendTextPlain(&buf, line)
+	case JSONLinesFormat:
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(newJSONLine(line))
+	case LogfmtFormat:
+		appendLogfmtPair(&buf, "ts", line.Time.Format(time.RFC3339Nano))
+		buf.WriteByte(' ')
+		appendLogfmtPair(&buf, "elapsed", line.Elapsed.String())
+		buf.WriteByte(' ')
+		if line.Label != "" {
+			appendLogfmtPair(&buf, "label", line.Label)
+			buf.WriteByte(' ')
+		}
+		if line.Stream != "" {
+			appendLogfmtPair(&buf, "stream", line.Stream)
+			buf.WriteByte(' ')
+		}
+		if line.Restart > 0 {
+			appendLogfmtPair(&buf, "restart", strconv.Itoa(line.Restart))
+			buf.WriteByte(' ')
+		}
+		if line.Partial {
+			buf.WriteString("partial=true ")
+		}
+		if line.Continued {
+			buf.WriteString("continued=true ")
+		}
+		if line.Wrapped {
+			buf.WriteString("wrapped=true ")
+		}
+		if line.Event != "" {
+			appendLogfmtPair(&buf, "event", line.Event)
+			buf.WriteByte(' ')
+			keys := make([]string, 0, len(line.Data))
+			for key := range line.Data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				appendLogfmtPair(&buf, key, fmt.Sprint(line.Data[key]))
+				buf.WriteByte(' ')
+			}
+		}
+		appendLogfmtPair(&buf, "msg", line.Text)
+		buf.WriteByte('\n')
+	default:
+		log.Panic("unknown output format ", p.format)
+	}
+	return buf.Bytes()
+}
+
+// appendLabelPlain appends label padded to the width of the widest label,
+// followed by a separator.
+func (p *Printer) appendLabelPlain(buf *bytes.Buffer, label string, color bool) {
+	if color {
+		buf.WriteString("\x1b[" + p.labels[label] + "m")
+	}
+	buf.WriteString(label)
+	for width := runewidth.StringWidth(label); width < p.labelWidth; width++ {
+		buf.WriteByte(' ')
+	}
+	if color {
+		buf.WriteString("\x1b[0m")
+	}
+	buf.WriteString(" | ")
+}
+
+func (p *Printer) appendTextPlain(buf *bytes.Buffer, line *Line) {
+	buf.WriteString(line.Text)
+	if line.Wrapped {
+		buf.WriteString(p.WrapMarker)
+		buf.WriteByte('\n')
+	} else {
+		buf.WriteString(line.Terminator)
+	}
+}
+
+type jsonLine struct {
+	Timestamp   string `json:"timestamp"`
+	Time        string `json:"time"`
+	Elapsed     int64  `json:"elapsed"`
+	Incremental int64  `json:"incremental"`
+	Line        string `json:"line"`
+	Terminator  string `json:"terminator"`
+	Label       string `json:"label,omitempty"`
+	Stream      string `json:"stream,omitempty"`
+	Restart     int    `json:"restart,omitempty"`
+	Partial     bool   `json:"partial,omitempty"`
+	Continued   bool   `json:"continued,omitempty"`
+	Wrapped     bool   `json:"wrapped,omitempty"`
+	Event       string `json:"event,omitempty"`
+	// Event data.
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+func newJSONLine(line *Line) *jsonLine {
+	return &jsonLine{
+		Timestamp:   line.Formatted,
+		Time:        line.Time.Format(time.RFC3339Nano),
+		Elapsed:     line.Elapsed.Nanoseconds(),
+		Incremental: line.Incremental.Nanoseconds(),
+		Line:        line.Text,
+		Terminator:  terminatorName(line.Terminator),
+		Label:       line.Label,
+		Stream:      line.Stream,
+		Restart:     line.Restart,
+		Partial:     line.Partial,
+		Continued:   line.Continued,
+		Wrapped:     line.Wrapped,
+		Event:       line.Event,
+		Data:        line.Data,
+	}
+}
+
+// terminatorName returns "LF", "CR", or "" for no line ending.
+func terminatorName(terminator string) string {
+	switch terminator {
+	case "\n":
+		return "LF"
+	case "\r":
+		return "CR"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,304 @@
+package ets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RestartPolicy determines when Supervise restarts the command.
+type RestartPolicy int
+
+const (
+	// NeverRestart runs the command once, like RunCommand.
+	NeverRestart RestartPolicy = iota
+	// RestartOnFailure restarts the command when it exits with a nonzero
+	// status or is terminated by a signal, including on timeout or stall.
+	RestartOnFailure
+	// RestartAlways restarts the command whenever it exits.
+	RestartAlways
+)
+
+// Defaults of SupervisorOptions.RestartDelay and MaxRestartDelay.
+const (
+	DefaultRestartDelay    = time.Second
+	DefaultMaxRestartDelay = time.Minute
+)
+
+// SupervisorOptions controls Supervise.
+type SupervisorOptions struct {
+	CommandOptions
+	Restart RestartPolicy
+	// MaxRestarts, if positive, limits the number of restarts.
+	MaxRestarts int
+	// RestartDelay is how long to wait before the first restart. The delay
+	// doubles with every restart up to MaxRestartDelay, and is reset once the
+	// command has run for at least MaxRestartDelay. Zero means
+	// DefaultRestartDelay and DefaultMaxRestartDelay respectively.
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+}
+
+// Supervise runs a command with RunCommand, restarting it when it exits as
+// opts.Restart says. Restarts are announced with "restart" events, e.g.
+// "restarting (attempt 3) after exit 1", and the number of restarts so far is
+// available to the timestamp format as %{restart}. The command isn't
+// restarted if it can't be started, nor if the current process receives
+// SIGINT, SIGQUIT or SIGTERM with ForwardSignals while waiting to restart it,
+// or less than KillGracePeriod before it exits, since it's then meant to exit.
+// SIGHUP doesn't prevent restarts, since it commonly asks to reload. Input
+// from Stdin goes to the current run.
+//
+// The returned error is that of the last run.
+func Supervise(args []string, printer *Printer, opts *SupervisorOptions) error {
+	if opts == nil {
+		opts = &SupervisorOptions{}
+	}
+	restartDelay := opts.RestartDelay
+	if restartDelay <= 0 {
+		restartDelay = DefaultRestartDelay
+	}
+	maxRestartDelay := opts.MaxRestartDelay
+	if maxRestartDelay <= 0 {
+		maxRestartDelay = DefaultMaxRestartDelay
+	}
+	killGracePeriod := opts.KillGracePeriod
+	if killGracePeriod <= 0 {
+		killGracePeriod = DefaultKillGracePeriod
+	}
+
+	// Signals that aren't forwarded terminate the current process anyway.
+	var terminating []os.Signal
+	if opts.ForwardSignals {
+		forwardedSignals := opts.ForwardedSignals
+		if forwardedSignals == nil {
+			forwardedSignals = DefaultForwardedSignals
+		}
+		for _, sig := range forwardedSignals {
+			switch sig {
+			case syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM:
+				terminating = append(terminating, sig)
+			}
+		}
+	}
+	// When a termination signal was last received, and a notification of it.
+	var terminatedMu sync.Mutex
+	var terminatedAt time.Time
+	terminated := make(chan struct{}, 1)
+	if len(terminating) > 0 {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, terminating...)
+		defer signal.Stop(sigs)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-sigs:
+					terminatedMu.Lock()
+					terminatedAt = time.Now()
+					terminatedMu.Unlock()
+					select {
+					case terminated <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	// terminatedSince reports whether a termination signal has been received
+	// since t.
+	terminatedSince := func(t time.Time) bool {
+		terminatedMu.Lock()
+		defer terminatedMu.Unlock()
+		return !terminatedAt.Before(t)
+	}
+
+	commandOpts := opts.CommandOptions
+	if opts.Stdin != nil {
+		// Input is copied to one run after another, rather than read by a
+		// run after it has ended.
+		commandOpts.stdinRelay = newStdinRelay(opts.Stdin, opts.NoPty)
+		defer commandOpts.stdinRelay.stop()
+	}
+
+	delay := restartDelay
+	for restarts := 0; ; restarts++ {
+		start := time.Now()
+		err := RunCommand(args, printer, &commandOpts)
+		exited := time.Now()
+		reason, ok := exitReason(err)
+		if !ok {
+			// Not started.
+			return err
+		}
+		if opts.Restart == NeverRestart || opts.Restart == RestartOnFailure && err == nil {
+			return err
+		}
+		since := exited.Add(-killGracePeriod)
+		if since.Before(start) {
+			since = start
+		}
+		if terminatedSince(since) {
+			return err
+		}
+		if opts.MaxRestarts > 0 && restarts >= opts.MaxRestarts {
+			text := fmt.Sprintf("not restarting after %s: limit of %d restarts reached", reason, opts.MaxRestarts)
+			_ = printer.PrintEvent("restart", text, map[string]interface{}{"reason": reason, "max_restarts": opts.MaxRestarts})
+			return err
+		}
+
+		if exited.Sub(start) >= maxRestartDelay {
+			delay = restartDelay
+		}
+		timer := time.NewTimer(delay)
+		for waiting := true; waiting; {
+			select {
+			case <-terminated:
+				// The notification may be of a signal the last run survived.
+				if terminatedSince(exited) {
+					timer.Stop()
+					return err
+				}
+			case <-timer.C:
+				waiting = false
+			}
+		}
+		printer.setRestarts(restarts + 1)
+		text := fmt.Sprintf("restarting (attempt %d) after %s", restarts+1, reason)
+		_ = printer.PrintEvent("restart", text, map[string]interface{}{
+			"attempt": restarts + 1,
+			"reason":  reason,
+			"delay":   delay.String(),
+		})
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// exitReason describes how a command run with RunCommand exited given its
+// error, e.g. "exit 1", "SIGSEGV" or "timeout", or returns false if it didn't
+// run.
+func exitReason(err error) (string, bool) {
+	var timeoutErr *TimeoutError
+	var stallErr *StallError
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "exit 0", true
+	case errors.As(err, &timeoutErr):
+		return "timeout", true
+	case errors.As(err, &stallErr):
+		return "stall", true
+	case errors.As(err, &exitErr):
+		status := NewExitStatus(exitErr.ProcessState)
+		if status.Signaled() {
+			return SignalName(status.Signal), true
+		}
+		return fmt.Sprintf("exit %d", status.Code), true
+	default:
+		return "", false
+	}
+}
+
+// stdinRelay copies input to the stdin of the current run of a supervised
+// command, if any. Input read while no command is running, or that the last
+// run didn't take, goes to the next run.
+type stdinRelay struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// stdin of the current run, or nil.
+	w *os.File
+	// Whether to close stdin at the end of input, i.e. with NoPty.
+	closeAtEOF bool
+	eof        bool
+	stopped    bool
+}
+
+func newStdinRelay(r io.Reader, closeAtEOF bool) *stdinRelay {
+	s := &stdinRelay{closeAtEOF: closeAtEOF}
+	s.cond = sync.NewCond(&s.mu)
+	go s.copy(r)
+	return s
+}
+
+func (s *stdinRelay) copy(r io.Reader) {
+	buf := make([]byte, 32*1024)
+	var failed *os.File
+	for {
+		n, err := r.Read(buf)
+		data := buf[:n]
+		for len(data) > 0 {
+			w := s.next(failed)
+			if w == nil {
+				return
+			}
+			written, err := w.Write(data)
+			data = data[written:]
+			if err != nil {
+				// The run has ended; retry with the next.
+				failed = w
+			}
+		}
+		if err != nil {
+			s.mu.Lock()
+			s.eof = true
+			if s.closeAtEOF && s.w != nil {
+				_ = s.w.Close()
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// next waits for a run other than the failed one, and returns its stdin, or
+// nil once stopped.
+func (s *stdinRelay) next(failed *os.File) *os.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.stopped && (s.w == nil || s.w == failed) {
+		s.cond.Wait()
+	}
+	if s.stopped {
+		return nil
+	}
+	return s.w
+}
+
+// attach starts copying to w, the stdin of a new run.
+func (s *stdinRelay) attach(w *os.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = w
+	if s.eof && s.closeAtEOF {
+		// Signal EOF to the command.
+		_ = w.Close()
+	}
+	s.cond.Broadcast()
+}
+
+// detach stops copying to the stdin of the run that has ended.
+func (s *stdinRelay) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = nil
+}
+
+// stop stops copying. A read in progress is abandoned.
+func (s *stdinRelay) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	s.cond.Broadcast()
+}
@@ -0,0 +1,180 @@
+package ets
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSupervise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Fails the first two times.
+	flaky := "echo run; test -e 2 && exit 0; test -e 1 && touch 2; touch 1; exit 1"
+	tests := []struct {
+		name     string
+		command  string
+		opts     SupervisorOptions
+		exitCode int
+		expected string
+	}{
+		{"never", flaky, SupervisorOptions{}, 1, "[0] run\n"},
+		{"on-failure", flaky, SupervisorOptions{Restart: RestartOnFailure}, 0,
+			"[0] run\n[1] restarting (attempt 1) after exit 1\n[1] run\n[2] restarting (attempt 2) after exit 1\n[2] run\n"},
+		{"max-restarts", flaky, SupervisorOptions{Restart: RestartOnFailure, MaxRestarts: 1}, 1,
+			"[0] run\n[1] restarting (attempt 1) after exit 1\n[1] run\n[1] not restarting after exit 1: limit of 1 restarts reached\n"},
+		{"always", "echo run", SupervisorOptions{Restart: RestartAlways, MaxRestarts: 1}, 0,
+			"[0] run\n[1] restarting (attempt 1) after exit 0\n[1] run\n[1] not restarting after exit 0: limit of 1 restarts reached\n"},
+		{"timeout", "echo run; sleep 1", SupervisorOptions{CommandOptions: CommandOptions{Timeout: 100 * time.Millisecond}, Restart: RestartOnFailure, MaxRestarts: 1}, -1,
+			"[0] run\n[0] timed out after 100ms, sending SIGTERM\n[1] restarting (attempt 1) after timeout\n[1] run\n[1] timed out after 100ms, sending SIGTERM\n[1] not restarting after timeout: limit of 1 restarts reached\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"1", "2"} {
+				_ = os.Remove(filepath.Join(dir, name))
+			}
+			timestamper, err := NewTimestamper("[%{restart}]", AbsoluteTimeMode, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			printer := NewPrinter(&buf, timestamper, PlainFormat)
+			test.opts.RestartDelay = 10 * time.Millisecond
+			test.opts.MaxRestartDelay = time.Second
+			test.opts.NoPty = true
+			err = Supervise([]string{"sh", "-c", "cd " + dir + "; " + test.command}, printer, &test.opts)
+			exitCode := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else if _, ok := err.(*TimeoutError); ok {
+				exitCode = -1
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if exitCode != test.exitCode {
+				t.Errorf("expected exit code %d, got %d", test.exitCode, exitCode)
+			}
+			if buf.String() != test.expected {
+				t.Errorf("wrong output: expected %#v, got %#v", test.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestSuperviseNotStarted(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = Supervise([]string{"/nonexistent"}, NewPrinter(&buf, timestamper, PlainFormat), &SupervisorOptions{Restart: RestartAlways})
+	if _, ok := err.(*os.PathError); !ok {
+		t.Errorf("expected start error, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output %#v", buf.String())
+	}
+}
+
+func TestSuperviseStdin(t *testing.T) {
+	timestamper, err := NewTimestamper("[%{restart}]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r, w := io.Pipe()
+	go func() {
+		_, _ = w.Write([]byte("one\n"))
+		// Arrives during the second run.
+		time.Sleep(500 * time.Millisecond)
+		_, _ = w.Write([]byte("two\n"))
+		_ = w.Close()
+	}()
+	opts := &SupervisorOptions{
+		CommandOptions: CommandOptions{Stdin: r, NoPty: true},
+		Restart:        RestartAlways,
+		MaxRestarts:    1,
+		RestartDelay:   10 * time.Millisecond,
+	}
+	err = Supervise([]string{"sh", "-c", "read line; echo $line"}, NewPrinter(&buf, timestamper, PlainFormat), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[0] one\n[1] restarting (attempt 1) after exit 0\n[1] two\n[1] not restarting after exit 0: limit of 1 restarts reached\n"
+	if buf.String() != expected {
+		t.Errorf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestSuperviseSignals(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	// Reloads on SIGHUP, then crashes. stderr, where the shell reports sleep
+	// killed by SIGHUP, is discarded.
+	command := "exec 2>/dev/null; trap 'echo reloaded' HUP; echo run; sleep 0.5; sleep 0.5; exit 1"
+	tests := []struct {
+		name     string
+		signal   syscall.Signal
+		expected string
+	}{
+		{"reload", syscall.SIGHUP,
+			"[0] run\n[0] reloaded\n[1] restarting (attempt 1) after exit 1\n[1] run\n[1] not restarting after exit 1: limit of 1 restarts reached\n"},
+		{"terminate", syscall.SIGTERM, "[0] run\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timestamper, err := NewTimestamper("[%{restart}]", AbsoluteTimeMode, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf lockedBuffer
+			done := make(chan error)
+			go func() {
+				opts := &SupervisorOptions{
+					CommandOptions: CommandOptions{NoPty: true, ForwardSignals: true},
+					Restart:        RestartOnFailure,
+					MaxRestarts:    1,
+					RestartDelay:   10 * time.Millisecond,
+				}
+				done <- Supervise([]string{"sh", "-c", command}, NewPrinter(&buf, timestamper, PlainFormat), opts)
+			}()
+			for start := time.Now(); buf.String() == "" && time.Since(start) < 5*time.Second; {
+				time.Sleep(10 * time.Millisecond)
+			}
+			_ = syscall.Kill(os.Getpid(), test.signal)
+			<-done
+			if buf.String() != test.expected {
+				t.Errorf("wrong output: expected %#v, got %#v", test.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestSuperviseDefaultDelay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	start := time.Now()
+	err = Supervise([]string{"true"}, NewPrinter(&buf, timestamper, PlainFormat), &SupervisorOptions{Restart: RestartAlways, MaxRestarts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < DefaultRestartDelay {
+		t.Errorf("restarted after %s, expected at least %s", elapsed, DefaultRestartDelay)
+	}
+}
@@ -0,0 +1,70 @@
+package ets
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ResourceUsage is the resource usage of a process, as reported by
+// getrusage(2) and wait4(2).
+type ResourceUsage struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the maximum resident set size in bytes.
+	MaxRSS int64
+	// Page faults not requiring I/O (minor) and requiring I/O (major).
+	MinorFaults int64
+	MajorFaults int64
+	// Context switches due to waiting (voluntary) and preemption
+	// (involuntary).
+	VoluntaryContextSwitches   int64
+	InvoluntaryContextSwitches int64
+}
+
+// NewResourceUsage converts a syscall.Rusage, e.g. from
+// (*os.ProcessState).SysUsage, to a ResourceUsage.
+func NewResourceUsage(ru *syscall.Rusage) ResourceUsage {
+	return ResourceUsage{
+		UserTime:                   time.Duration(ru.Utime.Nano()),
+		SystemTime:                 time.Duration(ru.Stime.Nano()),
+		MaxRSS:                     int64(ru.Maxrss) * maxRSSUnit,
+		MinorFaults:                int64(ru.Minflt),
+		MajorFaults:                int64(ru.Majflt),
+		VoluntaryContextSwitches:   int64(ru.Nvcsw),
+		InvoluntaryContextSwitches: int64(ru.Nivcsw),
+	}
+}
+
+// ChildrenResourceUsage returns the total resource usage of all descendants
+// of the current process that have terminated and been waited for.
+func ChildrenResourceUsage() (ResourceUsage, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &ru); err != nil {
+		return ResourceUsage{}, err
+	}
+	return NewResourceUsage(&ru), nil
+}
+
+// String describes the resource usage, e.g. "user 1.25s, system 120ms, max
+// RSS 45.2 MiB, page faults 1234 minor / 2 major, context switches 56
+// voluntary / 7 involuntary".
+func (u ResourceUsage) String() string {
+	return fmt.Sprintf("user %s, system %s, max RSS %.1f MiB, page faults %d minor / %d major, context switches %d voluntary / %d involuntary",
+		u.UserTime.Round(time.Millisecond), u.SystemTime.Round(time.Millisecond), float64(u.MaxRSS)/(1<<20),
+		u.MinorFaults, u.MajorFaults, u.VoluntaryContextSwitches, u.InvoluntaryContextSwitches)
+}
+
+// Data returns the resource usage as event data for Printer.PrintEvent, with
+// times in nanoseconds and sizes in bytes.
+func (u ResourceUsage) Data() map[string]interface{} {
+	return map[string]interface{}{
+		"user_time":                    u.UserTime.Nanoseconds(),
+		"system_time":                  u.SystemTime.Nanoseconds(),
+		"max_rss":                      u.MaxRSS,
+		"minor_faults":                 u.MinorFaults,
+		"major_faults":                 u.MajorFaults,
+		"voluntary_context_switches":   u.VoluntaryContextSwitches,
+		"involuntary_context_switches": u.InvoluntaryContextSwitches,
+	}
+}
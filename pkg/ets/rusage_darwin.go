@@ -0,0 +1,4 @@
+package ets
+
+// ru_maxrss is in bytes on macOS.
+const maxRSSUnit = 1
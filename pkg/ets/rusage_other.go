@@ -0,0 +1,6 @@
+//go:build !darwin
+
+package ets
+
+// ru_maxrss is in kilobytes on Linux and the BSDs.
+const maxRSSUnit = 1024
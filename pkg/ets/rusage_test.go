@@ -0,0 +1,37 @@
+package ets
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestResourceUsage(t *testing.T) {
+	ru := &syscall.Rusage{
+		Utime:  syscall.NsecToTimeval(int64(1250 * time.Millisecond)),
+		Stime:  syscall.NsecToTimeval(int64(120 * time.Millisecond)),
+		Maxrss: 46285 * (1024 / maxRSSUnit),
+		Minflt: 1234,
+		Majflt: 2,
+		Nvcsw:  56,
+		Nivcsw: 7,
+	}
+	usage := NewResourceUsage(ru)
+	expected := ResourceUsage{
+		UserTime:                   1250 * time.Millisecond,
+		SystemTime:                 120 * time.Millisecond,
+		MaxRSS:                     46285 * 1024,
+		MinorFaults:                1234,
+		MajorFaults:                2,
+		VoluntaryContextSwitches:   56,
+		InvoluntaryContextSwitches: 7,
+	}
+	if !reflect.DeepEqual(usage, expected) {
+		t.Fatalf("wrong usage: expected %+v, got %+v", expected, usage)
+	}
+	expectedString := "user 1.25s, system 120ms, max RSS 45.2 MiB, page faults 1234 minor / 2 major, context switches 56 voluntary / 7 involuntary"
+	if usage.String() != expectedString {
+		t.Errorf("wrong string: expected %#v, got %#v", expectedString, usage.String())
+	}
+}
@@ -0,0 +1,83 @@
+package ets
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// ProcessTreeSample is a sample of the resource usage of a process and its
+// descendants, taken with SampleProcessTree.
+type ProcessTreeSample struct {
+	// Time is when the sample was taken.
+	Time time.Time
+	// Processes is the number of processes in the tree.
+	Processes int
+	// CPUTime is the total user and system CPU time consumed so far by the
+	// processes currently in the tree.
+	CPUTime time.Duration
+	// RSS is the total resident set size in bytes.
+	RSS int64
+	// Threads is the total number of threads.
+	Threads int
+	// FDs is the total number of open file descriptors, not counting
+	// processes whose descriptors can't be inspected.
+	FDs int
+}
+
+// cpuPercent returns the CPU usage between the previous sample and s, in
+// percent of a single CPU. Processes exiting in between may make the CPU
+// time go down, in which case 0 is returned.
+func (s ProcessTreeSample) cpuPercent(previous ProcessTreeSample) float64 {
+	wall := s.Time.Sub(previous.Time)
+	cpu := s.CPUTime - previous.CPUTime
+	if wall <= 0 || cpu <= 0 {
+		return 0
+	}
+	return float64(cpu) / float64(wall) * 100
+}
+
+// startSampling prints a "sample" event describing the resource usage of the
+// process tree rooted at pid every interval. It returns a function stopping
+// the sampling.
+func startSampling(printer *Printer, pid int, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		previous, err := SampleProcessTree(pid)
+		if err != nil {
+			log.Println("error sampling command:", err)
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			sample, err := SampleProcessTree(pid)
+			if err != nil {
+				// Most likely the command has just exited.
+				continue
+			}
+			cpu := sample.cpuPercent(previous)
+			text := fmt.Sprintf("CPU %.1f%%, RSS %.1f MiB, %d processes, %d threads, %d fds",
+				cpu, float64(sample.RSS)/(1<<20), sample.Processes, sample.Threads, sample.FDs)
+			_ = printer.PrintEvent("sample", text, map[string]interface{}{
+				"cpu_percent": cpu,
+				"rss":         sample.RSS,
+				"processes":   sample.Processes,
+				"threads":     sample.Threads,
+				"fds":         sample.FDs,
+			})
+			previous = sample
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
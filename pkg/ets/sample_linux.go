@@ -0,0 +1,109 @@
+package ets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the unit of CPU times in /proc/PID/stat, USER_HZ, which is
+// 100 on all supported architectures.
+const clockTicks = 100
+
+// pfExiting is the PF_EXITING process flag, set once a process starts
+// exiting, e.g. has closed its files but isn't a zombie yet.
+const pfExiting = 0x4
+
+// procStat is the subset of /proc/PID/stat we're interested in.
+type procStat struct {
+	exited  bool
+	ppid    int
+	cpuTime time.Duration
+	threads int
+	rss     int64
+}
+
+// readProcStat parses /proc/PID/stat.
+func readProcStat(pid int) (*procStat, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name is parenthesized and may contain anything, so start
+	// after the last closing parenthesis, with field 3 (state).
+	i := strings.LastIndexByte(string(content), ')')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(content[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	// field returns field n as numbered in proc(5).
+	field := func(n int) int64 {
+		v, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return v
+	}
+	return &procStat{
+		exited:  fields[0] == "Z" || fields[0] == "X" || field(9)&pfExiting != 0,
+		ppid:    int(field(4)),
+		cpuTime: time.Duration(field(14)+field(15)) * time.Second / clockTicks,
+		threads: int(field(20)),
+		rss:     field(24) * int64(os.Getpagesize()),
+	}, nil
+}
+
+// SampleProcessTree samples the resource usage of the process pid and its
+// descendants from /proc. It is only supported on Linux.
+func SampleProcessTree(pid int) (ProcessTreeSample, error) {
+	sample := ProcessTreeSample{Time: time.Now()}
+	root, err := readProcStat(pid)
+	if err != nil {
+		return sample, err
+	}
+	if root.exited {
+		return sample, fmt.Errorf("process %d has exited", pid)
+	}
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return sample, err
+	}
+	stats := map[int]*procStat{pid: root}
+	children := map[int][]int{}
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil || p == pid {
+			continue
+		}
+		stat, err := readProcStat(p)
+		if err != nil || stat.exited {
+			// Exited.
+			continue
+		}
+		stats[p] = stat
+		children[stat.ppid] = append(children[stat.ppid], p)
+	}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		queue = append(queue, children[p]...)
+		stat := stats[p]
+		sample.Processes++
+		sample.CPUTime += stat.cpuTime
+		sample.RSS += stat.rss
+		sample.Threads += stat.threads
+		if fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", p)); err == nil {
+			sample.FDs += len(fds)
+		}
+	}
+	// The process may have started exiting while it was being sampled, e.g.
+	// closed its files.
+	if root, err = readProcStat(pid); err != nil || root.exited {
+		return sample, fmt.Errorf("process %d has exited", pid)
+	}
+	return sample, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package ets
+
+import "errors"
+
+// SampleProcessTree samples the resource usage of the process pid and its
+// descendants from /proc. It is only supported on Linux.
+func SampleProcessTree(pid int) (ProcessTreeSample, error) {
+	return ProcessTreeSample{}, errors.New("process sampling is only supported on Linux")
+}
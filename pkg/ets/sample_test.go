@@ -0,0 +1,46 @@
+package ets
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSampleProcessTree(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sampling is only supported on Linux")
+	}
+	cmd := exec.Command("sh", "-c", "sleep 1 & sleep 1; wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cmd.Wait() }()
+	time.Sleep(200 * time.Millisecond)
+	sample, err := SampleProcessTree(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.Processes != 3 || sample.Threads != 3 || sample.RSS <= 0 || sample.FDs < 3 {
+		t.Errorf("unexpected sample %+v", sample)
+	}
+}
+
+func TestProcessTreeSampleCPUPercent(t *testing.T) {
+	start := time.Now()
+	previous := ProcessTreeSample{Time: start, CPUTime: time.Second}
+	tests := []struct {
+		sample   ProcessTreeSample
+		expected float64
+	}{
+		{ProcessTreeSample{Time: start.Add(2 * time.Second), CPUTime: 2 * time.Second}, 50},
+		{ProcessTreeSample{Time: start.Add(time.Second), CPUTime: 3 * time.Second}, 200},
+		// A process with CPU time has exited.
+		{ProcessTreeSample{Time: start.Add(time.Second), CPUTime: 0}, 0},
+	}
+	for _, test := range tests {
+		if cpu := test.sample.cpuPercent(previous); cpu != test.expected {
+			t.Errorf("wrong CPU usage of %+v: expected %v, got %v", test.sample, test.expected, cpu)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package ets
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, s := range []string{"SIGHUP", "HUP", "sighup", "hup", "1"} {
+		sig, err := ParseSignal(s)
+		if err != nil || sig != syscall.SIGHUP {
+			t.Errorf("failed to parse %#v: got %v, %v", s, sig, err)
+		}
+	}
+	for _, s := range []string{"", "SIGNOPE", "0", "-1"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Errorf("expected error parsing %#v", s)
+		}
+	}
+	if name := SignalName(syscall.SIGSEGV); name != "SIGSEGV" {
+		t.Errorf("wrong name for SIGSEGV: %s", name)
+	}
+}
@@ -0,0 +1,224 @@
+package ets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// StreamOptions controls how PrintStream reads lines.
+type StreamOptions struct {
+	// FlushTimeout, if positive, is how long a partial line may wait for its
+	// line ending before it is printed anyway (see Printer.PrintPartial),
+	// e.g. so that prompts show up. The rest of the line continues it.
+	FlushTimeout time.Duration
+	// MaxLineLength, if positive, is the length in bytes beyond which a line
+	// is wrapped, i.e. split into several lines (see Printer.PrintWrapped).
+	// Otherwise lines may be arbitrarily long.
+	MaxLineLength int
+	// StampAtStart timestamps each line with the arrival time of its first
+	// byte, rather than the time it is complete.
+	StampAtStart bool
+	// Stop, if not nil, ends reading when closed, as if EOF was reached:
+	// data already read is printed, including the incomplete last line, if
+	// any, and PrintStream returns ErrStopped. A read in progress is
+	// abandoned.
+	Stop <-chan struct{}
+}
+
+// ErrStopped is returned by PrintStream when reading is ended by
+// StreamOptions.Stop.
+var ErrStopped = errors.New("stopped reading")
+
+// chunk is a chunk of data read from a stream, along with its arrival time.
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+// PrintStream reads lines from r until EOF or error, and prints them with
+// printer. Lines are split with ScanLines. stream names the stream for
+// printer.PrintLine. opts may be nil.
+//
+// The returned error is the error reading from r, if any, other than io.EOF,
+// or ErrStopped. Whatever was read before the error is printed regardless.
+func PrintStream(r io.Reader, printer *Printer, stream string, opts *StreamOptions) error {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+	chunks := make(chan chunk)
+	var readErr error
+	// Whether the reader is blocked in r.Read, and whether reading has been
+	// stopped. A chunk read is always handed over unless reading was stopped
+	// during the read, so that once stopped, everything read is printed.
+	var mu sync.Mutex
+	var reading, stopped bool
+	go func() {
+		defer close(chunks)
+		for {
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				return
+			}
+			reading = true
+			mu.Unlock()
+			buf := make([]byte, 4096)
+			n, err := r.Read(buf)
+			mu.Lock()
+			reading = false
+			abandoned := stopped
+			mu.Unlock()
+			if abandoned {
+				return
+			}
+			if n > 0 {
+				chunks <- chunk{buf[:n], time.Now()}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+		}
+	}()
+
+	lr := &lineReader{printer: printer, stream: stream, opts: opts}
+	var flushTimer *time.Timer
+	var flushTimeout <-chan time.Time
+	for {
+		select {
+		case c, ok := <-chunks:
+			if !ok {
+				lr.feed(nil, time.Now(), true)
+				return readErr
+			}
+			lr.feed(c.data, c.at, false)
+			if opts.FlushTimeout > 0 && len(lr.pending) > 0 {
+				if flushTimer == nil {
+					flushTimer = time.NewTimer(opts.FlushTimeout)
+				} else {
+					if !flushTimer.Stop() {
+						select {
+						case <-flushTimer.C:
+						default:
+						}
+					}
+					flushTimer.Reset(opts.FlushTimeout)
+				}
+				flushTimeout = flushTimer.C
+			} else {
+				flushTimeout = nil
+			}
+		case <-flushTimeout:
+			flushTimeout = nil
+			lr.flushPartial()
+		case <-opts.Stop:
+			// Take what the reader has in hand, without waiting for a read in
+			// progress.
+			mu.Lock()
+			stopped = true
+			inRead := reading
+			mu.Unlock()
+			if !inRead {
+				for c := range chunks {
+					lr.feed(c.data, c.at, false)
+				}
+			}
+			lr.feed(nil, time.Now(), true)
+			return ErrStopped
+		}
+	}
+}
+
+// lineReader splits data fed to it into lines and prints them.
+type lineReader struct {
+	printer *Printer
+	stream  string
+	opts    *StreamOptions
+	// Incomplete line, and the arrival time of its first byte.
+	pending      []byte
+	pendingSince time.Time
+	// Length of the leading part of pending known to hold no CR or LF, so
+	// that a long line arriving in many chunks is only searched once.
+	scanned int
+}
+
+// feed prints all complete lines in the pending data plus data, which
+// arrived at the given time, and keeps the remaining incomplete line, if any.
+// Lines longer than MaxLineLength are wrapped. At EOF, the remaining
+// incomplete line is printed as well.
+func (lr *lineReader) feed(data []byte, at time.Time, atEOF bool) {
+	if len(lr.pending) == 0 {
+		lr.pendingSince = at
+	}
+	lr.pending = append(lr.pending, data...)
+	maxLength := lr.opts.MaxLineLength
+	for {
+		if !atEOF && bytes.IndexAny(lr.pending[lr.scanned:], "\r\n") < 0 {
+			lr.scanned = len(lr.pending)
+			break
+		}
+		advance, token, _ := ScanLines(lr.pending, atEOF)
+		if advance == 0 {
+			break
+		}
+		lr.pending = lr.pending[advance:]
+		lr.scanned = 0
+		for maxLength > 0 && len(token)-len(lineEnding(token)) > maxLength {
+			n := wrapPoint(token, maxLength)
+			lr.print(&Line{Text: string(token[:n]), Wrapped: true})
+			token = token[n:]
+		}
+		text, terminator := splitLine(token)
+		lr.print(&Line{Text: text, Terminator: terminator})
+		// Whatever follows arrived in this chunk.
+		lr.pendingSince = at
+	}
+	for maxLength > 0 && len(lr.pending) > maxLength {
+		n := wrapPoint(lr.pending, maxLength)
+		lr.print(&Line{Text: string(lr.pending[:n]), Wrapped: true})
+		lr.pending = lr.pending[n:]
+		lr.scanned -= n
+		lr.pendingSince = at
+	}
+}
+
+// flushPartial prints the pending incomplete line as a partial line.
+func (lr *lineReader) flushPartial() {
+	lr.print(&Line{Text: string(lr.pending), Partial: true})
+	lr.pending = lr.pending[:0]
+	lr.scanned = 0
+}
+
+func (lr *lineReader) print(line *Line) {
+	at := time.Now()
+	if lr.opts.StampAtStart {
+		at = lr.pendingSince
+	}
+	line.Stream = lr.stream
+	_ = lr.printer.print(at, line)
+}
+
+// lineEnding returns the line ending of a token returned by ScanLines.
+func lineEnding(token []byte) []byte {
+	if n := len(token); n > 0 && (token[n-1] == '\n' || token[n-1] == '\r') {
+		return token[n-1:]
+	}
+	return nil
+}
+
+// wrapPoint returns where to wrap data to at most maxLength bytes, avoiding
+// splitting UTF-8 encoded characters when possible.
+func wrapPoint(data []byte, maxLength int) int {
+	for n := maxLength; n > 0 && n > maxLength-utf8.UTFMax; n-- {
+		if utf8.RuneStart(data[n]) {
+			return n
+		}
+	}
+	return maxLength
+}
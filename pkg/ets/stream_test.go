@@ -0,0 +1,333 @@
+package ets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"lf", "a\nb\n", []string{"a\n", "b\n"}},
+		{"cr", "a\rb\r", []string{"a\r", "b\r"}},
+		{"crlf", "a\r\nb\r\n", []string{"a\n", "b\n"}},
+		{"mixed", "a\r\r\nb\n\rc", []string{"a\r", "\n", "b\n", "\r", "c"}},
+		{"unterminated", "a\nb", []string{"a\n", "b"}},
+		{"empty", "", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(test.input))
+			scanner.Split(ScanLines)
+			tokens := make([]string, 0)
+			for scanner.Scan() {
+				tokens = append(tokens, scanner.Text())
+			}
+			if !reflect.DeepEqual(tokens, test.expected) {
+				t.Fatalf("wrong tokens: expected %#v, got %#v", test.expected, tokens)
+			}
+		})
+	}
+}
+
+func TestPrintStream(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	PrintStream(strings.NewReader("out1\r\nout2\rout3"), NewPrinter(&buf, timestamper, PlainFormat), "", nil)
+	expected := "[ts] out1\n[ts] out2\r[ts] out3"
+	if buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestPrintStreamJSONLines(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	PrintStream(strings.NewReader("<out1>\r\nout2\rout3"), NewPrinter(&buf, timestamper, JSONLinesFormat), "", nil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expected := []struct{ line, terminator string }{
+		{"<out1>", "LF"},
+		{"out2", "CR"},
+		{"out3", ""},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %#v", len(expected), lines)
+	}
+	for i, l := range lines {
+		var obj jsonLine
+		if err := json.Unmarshal([]byte(l), &obj); err != nil {
+			t.Fatalf("failed to parse %#v: %s", l, err)
+		}
+		if obj.Timestamp != "[ts]" || obj.Line != expected[i].line || obj.Terminator != expected[i].terminator {
+			t.Errorf("unexpected object %#v", obj)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, obj.Time); err != nil {
+			t.Errorf("bad time %#v: %s", obj.Time, err)
+		}
+		if obj.Elapsed < 0 || obj.Incremental < 0 {
+			t.Errorf("bad durations in %#v", obj)
+		}
+	}
+}
+
+func TestPrinterTee(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var terminal, file bytes.Buffer
+	printer := NewPrinter(&terminal, timestamper, PlainFormat)
+	printer.Color = true
+	printer.Tee(&file, TeeOptions{StripANSI: true})
+	PrintStream(strings.NewReader("\x1b[1mbold\x1b[0m\n"), printer, "", nil)
+	if expected := "\x1b[32m[ts]\x1b[0m \x1b[1mbold\x1b[0m\n"; terminal.String() != expected {
+		t.Errorf("wrong terminal output: expected %#v, got %#v", expected, terminal.String())
+	}
+	if expected := "[ts] bold\n"; file.String() != expected {
+		t.Errorf("wrong file output: expected %#v, got %#v", expected, file.String())
+	}
+}
+
+func TestPrintStreamFlushTimeout(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r, w := io.Pipe()
+	go func() {
+		_, _ = w.Write([]byte("Password: "))
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("secret\nok\n"))
+		_ = w.Close()
+	}()
+	PrintStream(r, NewPrinter(&buf, timestamper, PlainFormat), "", &StreamOptions{FlushTimeout: 50 * time.Millisecond})
+	expected := "[ts] Password: secret\n[ts] ok\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestPrinterInterruptPartial(t *testing.T) {
+	timestamper, err := NewTimestamper("[%{stream}]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, PlainFormat)
+	_ = printer.PrintPartial(StdoutStream, []byte("a"))
+	_ = printer.PrintPartial(StdoutStream, []byte("b"))
+	_ = printer.PrintLine(StderrStream, []byte("err\n"))
+	_ = printer.PrintLine(StdoutStream, []byte("c\n"))
+	expected := "[stdout] ab\n[stderr] err\n[stdout] c\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestPrintStreamMaxLineLength(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, PlainFormat)
+	printer.WrapMarker = "\\"
+	input := strings.Repeat("x", 12) + "\nabcdéfgh\nshort\n"
+	err = PrintStream(strings.NewReader(input), printer, "", &StreamOptions{MaxLineLength: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[ts] xxxxx\\\n[ts] xxxxx\\\n[ts] xx\n" +
+		"[ts] abcd\\\n[ts] éfgh\n" +
+		"[ts] short\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestPrintStreamLongLine(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	long := strings.Repeat("x", 1<<20)
+	err = PrintStream(strings.NewReader(long+"\nafter\n"), NewPrinter(&buf, timestamper, PlainFormat), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[ts] " + long + "\n[ts] after\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output of length %d", buf.Len())
+	}
+}
+
+// chunkReader reads at most size bytes at a time.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (r chunkReader) Read(p []byte) (int, error) {
+	if len(p) > r.size {
+		p = p[:r.size]
+	}
+	return r.r.Read(p)
+}
+
+func TestPrintStreamLongLineInSmallChunks(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	long := strings.Repeat("x", 8<<20)
+	r := chunkReader{strings.NewReader(long + "\nafter\n"), 100}
+	start := time.Now()
+	err = PrintStream(r, NewPrinter(&buf, timestamper, PlainFormat), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("took too long: %s", elapsed)
+	}
+	expected := "[ts] " + long + "\n[ts] after\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output of length %d", buf.Len())
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return copy(p, "partial"), errors.New("read failure")
+}
+
+func TestPrintStreamReadError(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = PrintStream(failingReader{}, NewPrinter(&buf, timestamper, PlainFormat), "", nil)
+	if err == nil || err.Error() != "read failure" {
+		t.Fatalf("expected read failure, got %v", err)
+	}
+	if expected := "[ts] partial"; buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestPrintStreamStampAtStart(t *testing.T) {
+	for _, stampAtStart := range []bool{false, true} {
+		timestamper, err := NewTimestamper("%{seconds}", ElapsedTimeMode, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		r, w := io.Pipe()
+		go func() {
+			_, _ = w.Write([]byte("slow "))
+			time.Sleep(300 * time.Millisecond)
+			_, _ = w.Write([]byte("line\nfast line\n"))
+			_ = w.Close()
+		}()
+		err = PrintStream(r, NewPrinter(&buf, timestamper, PlainFormat), "", &StreamOptions{StampAtStart: stampAtStart})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var first, second float64
+		if _, err := fmt.Sscanf(buf.String(), "%f slow line\n%f fast line\n", &first, &second); err != nil {
+			t.Fatalf("failed to parse output %#v: %s", buf.String(), err)
+		}
+		if stampAtStart && first >= 0.3 || !stampAtStart && first < 0.3 {
+			t.Errorf("wrong timestamp of slow line with StampAtStart=%v: %v", stampAtStart, first)
+		}
+		if second < 0.3 {
+			t.Errorf("wrong timestamp of fast line with StampAtStart=%v: %v", stampAtStart, second)
+		}
+	}
+}
+
+func TestPrintStreamStop(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r, w := io.Pipe()
+	defer w.Close()
+	stop := make(chan struct{})
+	go func() {
+		_, _ = w.Write([]byte("line\nincomplete"))
+		time.Sleep(100 * time.Millisecond)
+		close(stop)
+	}()
+	err = PrintStream(r, NewPrinter(&buf, timestamper, PlainFormat), "", &StreamOptions{Stop: stop})
+	if err != ErrStopped {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+	if expected := "[ts] line\n[ts] incomplete"; buf.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+// blockingWriter blocks its first write until release is closed.
+type blockingWriter struct {
+	bytes.Buffer
+	release chan struct{}
+	blocked bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if !w.blocked {
+		w.blocked = true
+		<-w.release
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestPrintStreamStopWithChunkInHand(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &blockingWriter{release: make(chan struct{})}
+	r, w := io.Pipe()
+	defer w.Close()
+	stop := make(chan struct{})
+	go func() {
+		// The first line is stuck printing while the second is read, so
+		// reading is stopped with the second line in hand.
+		_, _ = w.Write([]byte("one\n"))
+		_, _ = w.Write([]byte("two\n"))
+		time.Sleep(100 * time.Millisecond)
+		close(stop)
+		close(out.release)
+	}()
+	err = PrintStream(r, NewPrinter(out, timestamper, PlainFormat), "", &StreamOptions{Stop: stop})
+	if err != ErrStopped {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+	if expected := "[ts] one\n[ts] two\n"; out.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, out.String())
+	}
+}
@@ -0,0 +1,97 @@
+package ets
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Stats are statistics of the lines printed by a Printer, not counting
+// events.
+type Stats struct {
+	// Lines is the number of lines printed. Pieces of wrapped lines count
+	// as lines of their own, while the rest of a partial line doesn't.
+	Lines int
+	// Bytes is the number of bytes printed, including line endings.
+	Bytes int64
+	// LongestGap is the longest time between the timestamps of two
+	// consecutive lines (or the Timestamper's start and the first line),
+	// and LongestGapLine is the line that ended it. The rest of a partial
+	// line counts as the end of the line.
+	LongestGap     time.Duration
+	LongestGapLine string
+}
+
+// Stats returns statistics of the lines printed so far.
+func (p *Printer) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// recordStats accounts line, which isn't an event, in the Printer's Stats.
+func (p *Printer) recordStats(line *Line) {
+	p.stats.Bytes += int64(len(line.Text) + len(line.Terminator))
+	previous := p.lastLineStamp
+	if line.Time.After(p.lastLineStamp) {
+		p.lastLineStamp = line.Time
+	}
+	if line.Continued {
+		return
+	}
+	p.stats.Lines++
+	if previous.IsZero() {
+		previous = p.timestamper.StartTimestamp
+	}
+	if gap := line.Time.Sub(previous); gap > p.stats.LongestGap {
+		p.stats.LongestGap = gap
+		p.stats.LongestGapLine = line.Text
+	}
+}
+
+// maxSummaryLineLength is the length beyond which the line quoted in a
+// summary is truncated.
+const maxSummaryLineLength = 40
+
+// PrintSummary prints a "summary" event describing the run so far: start
+// (the Timestamper's start) and end (now) times, elapsed time, Stats, and
+// the command's exit status, if status isn't nil.
+func (p *Printer) PrintSummary(status *ExitStatus) error {
+	stats := p.Stats()
+	start := p.timestamper.StartTimestamp.In(p.timestamper.TZ)
+	end := time.Now().In(p.timestamper.TZ)
+	elapsed := end.Sub(start).Round(time.Millisecond)
+	const timeFormat = "2006-01-02 15:04:05"
+	text := fmt.Sprintf("started %s, ended %s, elapsed %s, %d lines, %d bytes",
+		start.Format(timeFormat), end.Format(timeFormat), elapsed, stats.Lines, stats.Bytes)
+	data := map[string]interface{}{
+		"start":   start.Format(time.RFC3339Nano),
+		"end":     end.Format(time.RFC3339Nano),
+		"elapsed": elapsed.String(),
+		"lines":   stats.Lines,
+		"bytes":   stats.Bytes,
+	}
+	if stats.Lines > 0 {
+		gap := stats.LongestGap.Round(time.Millisecond)
+		text += fmt.Sprintf(", longest gap %s before %q", gap, truncate(stats.LongestGapLine, maxSummaryLineLength))
+		data["longest_gap"] = gap.String()
+		data["longest_gap_line"] = stats.LongestGapLine
+	}
+	if status != nil {
+		text += ", " + status.String()
+		for key, value := range status.Data() {
+			data[key] = value
+		}
+	}
+	return p.PrintEvent("summary", text, data)
+}
+
+// truncate truncates s to at most n runes, marking truncation with an
+// ellipsis.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
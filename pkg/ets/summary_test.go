@@ -0,0 +1,47 @@
+package ets
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPrinterStats(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := timestamper.StartTimestamp
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, PlainFormat)
+	_ = printer.print(start.Add(time.Second), &Line{Text: "first", Terminator: "\n"})
+	_ = printer.print(start.Add(5*time.Second), &Line{Text: "slow", Partial: true})
+	_ = printer.print(start.Add(9*time.Second), &Line{Text: " line", Terminator: "\n"})
+	_ = printer.PrintEvent("event", "not counted", nil)
+	_ = printer.print(start.Add(10*time.Second), &Line{Text: "last", Terminator: "\r"})
+	expected := Stats{
+		Lines:          3,
+		Bytes:          21,
+		LongestGap:     4 * time.Second,
+		LongestGapLine: "slow",
+	}
+	if stats := printer.Stats(); stats != expected {
+		t.Errorf("wrong stats: expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestPrinterPrintSummary(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, timestamper, PlainFormat)
+	_ = printer.PrintLine("", []byte("a line longer than forty characters, to be truncated\n"))
+	_ = printer.PrintSummary(&ExitStatus{Code: 1})
+	pattern := regexp.MustCompile(`^\[ts\] a line.*\n\[ts\] started \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}, ended \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}, elapsed \S+, 1 lines, 53 bytes, longest gap \S+ before "a line longer than forty characters, to…", exited with status 1\n$`)
+	if !pattern.MatchString(buf.String()) {
+		t.Errorf("wrong output: %#v", buf.String())
+	}
+}
@@ -0,0 +1,78 @@
+package ets
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// terminal is a tty whose settings can be switched to raw mode and back.
+type terminal struct {
+	file     *os.File
+	original syscall.Termios
+	mu       sync.Mutex
+	raw      bool
+}
+
+// newTerminal returns a terminal for f, or nil if f isn't a tty (or the
+// platform is unsupported).
+func newTerminal(f *os.File) *terminal {
+	t := &terminal{file: f}
+	if err := getTermios(f.Fd(), &t.original); err != nil {
+		return nil
+	}
+	return t
+}
+
+// makeRaw switches the terminal to raw mode, except that output processing
+// (in particular LF to CRLF translation) is retained, since timestamped
+// output is written to the same terminal, as are signal-generating keys
+// (e.g. Ctrl-C and Ctrl-Z), so that they keep working through signal
+// forwarding and job control.
+func (t *terminal) makeRaw() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	termios := t.original
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := setTermios(t.file.Fd(), &termios); err != nil {
+		return err
+	}
+	t.raw = true
+	return nil
+}
+
+// restore restores the terminal's original settings, if changed.
+func (t *terminal) restore() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.raw {
+		return nil
+	}
+	if err := setTermios(t.file.Fd(), &t.original); err != nil {
+		return err
+	}
+	t.raw = false
+	return nil
+}
+
+func getTermios(fd uintptr, termios *syscall.Termios) error {
+	return ioctl(fd, ioctlGetTermios, uintptr(unsafe.Pointer(termios)))
+}
+
+func setTermios(fd uintptr, termios *syscall.Termios) error {
+	return ioctl(fd, ioctlSetTermios, uintptr(unsafe.Pointer(termios)))
+}
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package ets
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+	// FIONREAD, i.e. _IOR('f', 127, int), missing from package syscall.
+	ioctlInputQueue = 0x4004667f
+)
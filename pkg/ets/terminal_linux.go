@@ -0,0 +1,11 @@
+//go:build linux
+
+package ets
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+	ioctlInputQueue = syscall.TIOCINQ
+)
@@ -0,0 +1,268 @@
+package ets
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/strftime"
+)
+
+// TimestampMode determines what a Timestamper measures.
+type TimestampMode int
+
+const (
+	// AbsoluteTimeMode shows wall clock time.
+	AbsoluteTimeMode TimestampMode = iota
+	// ElapsedTimeMode shows time elapsed since the Timestamper was created.
+	ElapsedTimeMode
+	// IncrementalTimeMode shows time elapsed since the last timestamp.
+	IncrementalTimeMode
+)
+
+// Timestamper formats timestamps according to a strftime-style format
+// string and a TimestampMode.
+type Timestamper struct {
+	Mode           TimestampMode
+	TZ             *time.Location
+	StartTimestamp time.Time
+	LastTimestamp  time.Time
+	segments       []formatSegment
+}
+
+// formatSegment is either a chunk of strftime format string, a %{name}
+// field directive substituted with a per-line value, or a %{name} duration
+// directive.
+type formatSegment struct {
+	formatter *strftime.Strftime
+	field     string
+	duration  string
+}
+
+// fieldDirectives lists the names accepted in %{name} field directives.
+var fieldDirectives = []string{"stream", "label", "restart"}
+
+// durationDirectives maps the names accepted in %{name} duration directives
+// to their implementations. Unlike strftime directives, which format a
+// duration as a time of day on January 1, 1970 and hence wrap around after
+// 24 hours, these format totals.
+var durationDirectives = map[string]func(b []byte, d time.Duration) []byte{
+	// Total days.
+	"days": func(b []byte, d time.Duration) []byte {
+		return strconv.AppendInt(b, int64(d/(24*time.Hour)), 10)
+	},
+	// Total hours, at least two digits.
+	"hours": func(b []byte, d time.Duration) []byte {
+		return appendPadded(b, int64(d/time.Hour))
+	},
+	// Total minutes, at least two digits.
+	"minutes": func(b []byte, d time.Duration) []byte {
+		return appendPadded(b, int64(d/time.Minute))
+	},
+	// Total seconds with millisecond fraction.
+	"seconds": func(b []byte, d time.Duration) []byte {
+		return strconv.AppendFloat(b, float64(d/time.Millisecond)/1000, 'f', 3, 64)
+	},
+}
+
+func appendPadded(b []byte, n int64) []byte {
+	if n < 10 {
+		b = append(b, '0')
+	}
+	return strconv.AppendInt(b, n, 10)
+}
+
+// NewTimestamper returns a Timestamper whose clock starts now. In addition to
+// the standard strftime directives, %L (milliseconds), %f (microseconds) and
+// %s (Unix seconds) are supported, as well as the %{stream}, %{label} and
+// %{restart} field directives, whose values are supplied to Stamp, and, in
+// elapsed and incremental modes, the %{days}, %{hours}, %{minutes} and
+// %{seconds} directives for durations in total days, hours, minutes and
+// (fractional) seconds.
+func NewTimestamper(format string, mode TimestampMode, timezone *time.Location) (*Timestamper, error) {
+	segments, err := compileFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	if mode == AbsoluteTimeMode {
+		for _, segment := range segments {
+			if segment.duration != "" {
+				return nil, fmt.Errorf("directive %%{%s} is only supported in elapsed and incremental modes", segment.duration)
+			}
+		}
+	}
+	now := time.Now()
+	return &Timestamper{
+		Mode:           mode,
+		TZ:             timezone,
+		StartTimestamp: now,
+		LastTimestamp:  now,
+		segments:       segments,
+	}, nil
+}
+
+func compileFormat(format string) ([]formatSegment, error) {
+	segments := make([]formatSegment, 0)
+	var chunk strings.Builder
+	flushChunk := func() error {
+		if chunk.Len() == 0 {
+			return nil
+		}
+		formatter, err := strftime.New(chunk.String(),
+			strftime.WithMilliseconds('L'),
+			strftime.WithUnixSeconds('s'),
+			strftime.WithSpecification('f', microseconds))
+		if err != nil {
+			return err
+		}
+		segments = append(segments, formatSegment{formatter: formatter})
+		chunk.Reset()
+		return nil
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			chunk.WriteByte(format[i])
+			continue
+		}
+		if format[i+1] != '{' {
+			// Pass the directive (including %%) on to strftime.
+			chunk.WriteString(format[i : i+2])
+			i++
+			continue
+		}
+		end := strings.IndexByte(format[i+2:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated directive in format %q", format)
+		}
+		name := format[i+2 : i+2+end]
+		var segment formatSegment
+		if isFieldDirective(name) {
+			segment.field = name
+		} else if _, ok := durationDirectives[name]; ok {
+			segment.duration = name
+		} else {
+			return nil, fmt.Errorf("unknown directive %%{%s}", name)
+		}
+		if err := flushChunk(); err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+		i += 2 + end
+	}
+	if err := flushChunk(); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+func isFieldDirective(name string) bool {
+	for _, n := range fieldDirectives {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Timestamp is a single timestamp taken by a Timestamper.
+type Timestamp struct {
+	// Time is the wall clock time, in the Timestamper's timezone.
+	Time time.Time
+	// Elapsed is the time elapsed since the Timestamper was created.
+	Elapsed time.Duration
+	// Incremental is the time elapsed since the previous timestamp.
+	Incremental time.Duration
+	// Formatted is the timestamp formatted according to the Timestamper's
+	// format string and mode.
+	Formatted string
+}
+
+// Stamp returns the timestamp for now, and records it as the last timestamp.
+// fields supplies the values of %{name} directives; missing values are
+// formatted as empty strings.
+//
+// now may be earlier than the last timestamp, e.g. when lines are stamped
+// with their arrival time. The incremental duration is then zero, and the
+// last timestamp is left alone.
+func (t *Timestamper) Stamp(now time.Time, fields map[string]string) Timestamp {
+	ts := t.peek(now, fields)
+	if now.After(t.LastTimestamp) {
+		t.LastTimestamp = now
+	}
+	return ts
+}
+
+// peek is Stamp without recording the timestamp.
+func (t *Timestamper) peek(now time.Time, fields map[string]string) Timestamp {
+	return Timestamp{
+		Time:        now.In(t.TZ),
+		Elapsed:     now.Sub(t.StartTimestamp),
+		Incremental: t.incremental(now),
+		Formatted:   t.format(now, fields),
+	}
+}
+
+func (t *Timestamper) incremental(now time.Time) time.Duration {
+	if d := now.Sub(t.LastTimestamp); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// CurrentTimestampString returns the formatted timestamp for the current
+// time, and records it as the last timestamp.
+func (t *Timestamper) CurrentTimestampString() string {
+	return t.Stamp(time.Now(), nil).Formatted
+}
+
+func (t *Timestamper) format(now time.Time, fields map[string]string) string {
+	var value time.Time
+	var duration time.Duration
+	switch t.Mode {
+	case AbsoluteTimeMode:
+		value = now.In(t.TZ)
+	case ElapsedTimeMode:
+		duration = now.Sub(t.StartTimestamp)
+		value = durationTime(duration)
+	case IncrementalTimeMode:
+		duration = t.incremental(now)
+		value = durationTime(duration)
+	default:
+		log.Panic("unknown mode ", t.Mode)
+	}
+	var b []byte
+	for _, segment := range t.segments {
+		switch {
+		case segment.formatter != nil:
+			b = segment.formatter.FormatBuffer(b, value)
+		case segment.duration != "":
+			b = durationDirectives[segment.duration](b, duration)
+		default:
+			b = append(b, fields[segment.field]...)
+		}
+	}
+	return string(b)
+}
+
+func durationTime(duration time.Duration) time.Time {
+	return time.Unix(0, duration.Nanoseconds()).UTC()
+}
+
+var microseconds strftime.Appender
+
+func init() {
+	microseconds = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+		microsecond := int(t.Nanosecond()) / int(time.Microsecond)
+		if microsecond == 0 {
+			return append(b, "000000"...)
+		} else {
+			length := int(math.Log10(float64(microsecond))) + 1
+			b = append(b, bytes.Repeat([]byte("0"), 6-length)...)
+			return append(b, strconv.Itoa(microsecond)...)
+		}
+	})
+}
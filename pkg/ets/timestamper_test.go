@@ -0,0 +1,67 @@
+package ets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFieldDirectives(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"[%{stream}]", "[stderr]"},
+		{"%Y %{stream} %Y", "1970 stderr 1970"},
+		{"%%{stream}", "%{stream}"},
+		{"%{stream}%{stream}", "stderrstderr"},
+	}
+	for _, test := range tests {
+		timestamper, err := NewTimestamper(test.format, AbsoluteTimeMode, time.UTC)
+		if err != nil {
+			t.Fatalf("failed to create timestamper for %#v: %s", test.format, err)
+		}
+		s := timestamper.Stamp(time.Unix(0, 0), map[string]string{"stream": "stderr"}).Formatted
+		if s != test.expected {
+			t.Errorf("wrong output for %#v: expected %#v, got %#v", test.format, test.expected, s)
+		}
+	}
+	for _, format := range []string{"%{nonexistent}", "%{stream"} {
+		if _, err := NewTimestamper(format, AbsoluteTimeMode, time.UTC); err == nil {
+			t.Errorf("expected error for format %#v", format)
+		}
+	}
+}
+
+func TestDurationDirectives(t *testing.T) {
+	duration := 50*time.Hour + 3*time.Minute + 4*time.Second + 567*time.Millisecond
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"%T", "02:03:04"},
+		{"%{hours}:%M:%S", "50:03:04"},
+		{"%{days}d %H:%M:%S.%L", "2d 02:03:04.567"},
+		{"%{minutes}m", "3003m"},
+		{"%{seconds}", "180184.567"},
+	}
+	for _, test := range tests {
+		timestamper, err := NewTimestamper(test.format, ElapsedTimeMode, time.UTC)
+		if err != nil {
+			t.Fatalf("failed to create timestamper for %#v: %s", test.format, err)
+		}
+		s := timestamper.Stamp(timestamper.StartTimestamp.Add(duration), nil).Formatted
+		if s != test.expected {
+			t.Errorf("wrong output for %#v: expected %#v, got %#v", test.format, test.expected, s)
+		}
+	}
+	timestamper, err := NewTimestamper("%{hours}:%M", ElapsedTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := timestamper.Stamp(timestamper.StartTimestamp.Add(time.Minute), nil).Formatted; s != "00:01" {
+		t.Errorf("wrong output for short duration: %#v", s)
+	}
+	if _, err := NewTimestamper("%{hours}", AbsoluteTimeMode, time.UTC); err == nil {
+		t.Error("expected error for duration directive in absolute time mode")
+	}
+}
@@ -0,0 +1,85 @@
+package ets
+
+import (
+	"io"
+	"sync"
+)
+
+// TimestampWriter is an io.WriteCloser prefixing each line written to it with
+// a timestamp before passing it on to the underlying writer. Lines are split
+// with ScanLines; an incomplete line is buffered until its line ending is
+// written, or until Close. A trailing CR is held back until the next write
+// (or Close) tells whether it is followed by LF.
+//
+// TimestampWriter is safe for concurrent use, so it can be used as both
+// Stdout and Stderr of an exec.Cmd, or as the output of a log.Logger.
+type TimestampWriter struct {
+	// Stream is passed on to Printer.PrintLine. It must be set before first
+	// use.
+	Stream string
+
+	printer *Printer
+	mu      sync.Mutex
+	buf     []byte
+	closed  bool
+}
+
+// NewTimestampWriter returns a TimestampWriter writing plain timestamped
+// lines to w.
+func NewTimestampWriter(w io.Writer, timestamper *Timestamper) *TimestampWriter {
+	return NewPrinterWriter(NewPrinter(w, timestamper, PlainFormat))
+}
+
+// NewPrinterWriter returns a TimestampWriter printing lines with printer.
+func NewPrinterWriter(printer *Printer) *TimestampWriter {
+	return &TimestampWriter{printer: printer}
+}
+
+// Write writes all complete lines in p (together with any buffered partial
+// line) to the underlying writer, and buffers the rest. Once buffered, p
+// counts as written even if writing a line fails, so that retrying doesn't
+// duplicate it; the line that failed is dropped.
+func (tw *TimestampWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	tw.buf = append(tw.buf, p...)
+	return len(p), tw.flush(false)
+}
+
+// Close writes out the final partial line, if any. The underlying writer is
+// not closed.
+func (tw *TimestampWriter) Close() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.closed {
+		return nil
+	}
+	tw.closed = true
+	return tw.flush(true)
+}
+
+func (tw *TimestampWriter) flush(atEOF bool) error {
+	data := tw.buf
+	for {
+		scan := data
+		if !atEOF && len(scan) > 0 && scan[len(scan)-1] == '\r' {
+			// Hold back a trailing CR, which may be the start of a CRLF split
+			// across writes.
+			scan = scan[:len(scan)-1]
+		}
+		advance, token, _ := ScanLines(scan, atEOF)
+		if advance == 0 {
+			break
+		}
+		data = data[advance:]
+		if err := tw.printer.PrintLine(tw.Stream, token); err != nil {
+			tw.buf = tw.buf[:copy(tw.buf, data)]
+			return err
+		}
+	}
+	tw.buf = tw.buf[:copy(tw.buf, data)]
+	return nil
+}
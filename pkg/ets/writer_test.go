@@ -0,0 +1,94 @@
+package ets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewTimestampWriter(&buf, timestamper)
+	for _, chunk := range []string{"ou", "t1\nout2\r\n", "out3\rout", "4"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "[ts] out1\n[ts] out2\n[ts] out3\r"
+	if buf.String() != expected {
+		t.Fatalf("wrong output before Close: expected %#v, got %#v", expected, buf.String())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected += "[ts] out4"
+	if buf.String() != expected {
+		t.Fatalf("wrong output after Close: expected %#v, got %#v", expected, buf.String())
+	}
+	if _, err := w.Write([]byte("more\n")); err == nil {
+		t.Fatal("expected error writing to closed writer")
+	}
+}
+
+func TestTimestampWriterSplitCRLF(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewTimestampWriter(&buf, timestamper)
+	for _, chunk := range []string{"a\r", "\nb\n", "c\r"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "[ts] a\n[ts] b\n"
+	if buf.String() != expected {
+		t.Fatalf("wrong output before Close: expected %#v, got %#v", expected, buf.String())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected += "[ts] c\r"
+	if buf.String() != expected {
+		t.Fatalf("wrong output after Close: expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+// flakyWriter fails its first write.
+type flakyWriter struct {
+	bytes.Buffer
+	failed bool
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if !w.failed {
+		w.failed = true
+		return 0, errors.New("write failure")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestTimestampWriterError(t *testing.T) {
+	timestamper, err := NewTimestamper("[ts]", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out flakyWriter
+	w := NewTimestampWriter(&out, timestamper)
+	p := []byte("lost\nkept")
+	if n, err := w.Write(p); err == nil || n != len(p) {
+		t.Fatalf("expected %d bytes written with an error, got %d, %v", len(p), n, err)
+	}
+	if _, err := w.Write([]byte("\n")); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] kept\n"; out.String() != expected {
+		t.Fatalf("wrong output: expected %#v, got %#v", expected, out.String())
+	}
+}
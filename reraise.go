@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// reraise attempts to terminate ets with sig, so that ets's parent sees the
+// same termination status as that of the command. If ets survives, it
+// returns, and the caller should exit with 128+sig like a shell would.
+func reraise(sig syscall.Signal) {
+	signal.Reset(sig)
+	// The Go runtime doesn't die from some signals (e.g. SIGSEGV or SIGUSR1)
+	// sent with kill, even without handlers registered with os/signal.
+	_ = resetSignalDisposition(sig)
+	_ = syscall.Kill(os.Getpid(), sig)
+	// Give the signal a moment to be delivered.
+	time.Sleep(100 * time.Millisecond)
+}
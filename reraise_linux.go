@@ -0,0 +1,21 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// resetSignalDisposition sets the disposition of sig to SIG_DFL, bypassing
+// the Go runtime's handler.
+func resetSignalDisposition(sig syscall.Signal) error {
+	// A zeroed struct sigaction, i.e. SIG_DFL with no flags and an empty mask.
+	// Oversized to accommodate the layouts on all architectures.
+	var action [8]uint64
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(&action)), 0, 8, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// resetSignalDisposition is a no-op on this platform. Only signals the Go
+// runtime dies from by default (e.g. SIGHUP, SIGINT, SIGTERM) can be
+// reraised.
+func resetSignalDisposition(sig syscall.Signal) error {
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build linux
+
+package main_test
+
+import "syscall"
+
+const ioctlGetTermios = syscall.TCGETS